@@ -0,0 +1,114 @@
+//sdpdump prints sdp frames of the old length-prefixed format (stnet.PackSdpProtocol)
+//or of the v1 format (stnet.PackSdpFrame), read from a file or stdin
+//usage: sdpdump [-json req|rsp|reqproto|rspproto] [file]
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sstask/golib/stnet"
+)
+
+var prototypes = map[string]interface{}{
+	"req":      stnet.RequestPacket{},
+	"rsp":      stnet.ResponsePacket{},
+	"reqproto": stnet.ReqProto{},
+	"rspproto": stnet.RspProto{},
+}
+
+func main() {
+	jsonType := flag.String("json", "", "print frames as json using a known prototype: req, rsp, reqproto, rspproto")
+	flag.Parse()
+
+	var prototype interface{}
+	if *jsonType != "" {
+		p, ok := prototypes[*jsonType]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown prototype %q\n", *jsonType)
+			os.Exit(2)
+		}
+		prototype = p
+	}
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if err := dumpFrames(bufio.NewReader(in), prototype); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func dumpFrames(r io.Reader, prototype interface{}) error {
+	for n := 0; ; n++ {
+		frame, err := readFrame(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("frame %d: %s", n, err.Error())
+		}
+		_, flags, data, err := stnet.UnpackSdpFrame(frame)
+		if frame[0] == stnet.SdpFrameMagic {
+			fmt.Printf("frame %d len=%d v1 flags=%#x\n", n, len(frame), flags)
+		} else {
+			fmt.Printf("frame %d len=%d\n", n, len(frame))
+		}
+		if err != nil {
+			fmt.Printf("<error: %s>\n", err.Error())
+			continue
+		}
+		if flags&(stnet.SdpFrameHello|stnet.SdpFrameAck) != 0 {
+			fmt.Println("<frame negotiation>")
+		}
+		if prototype == nil {
+			fmt.Print(stnet.Dump(data))
+			continue
+		}
+		js, err := stnet.ToJSON(data, prototype)
+		if err != nil {
+			fmt.Printf("<error: %s>\n", err.Error())
+			fmt.Print(stnet.Dump(data))
+			continue
+		}
+		fmt.Println(string(js))
+	}
+}
+
+//readFrame reads a whole frame of the old 4 bytes length header or of the v1 header
+func readFrame(r io.Reader) ([]byte, error) {
+	head := make([]byte, stnet.SdpFrameHeadLen)
+	if _, err := io.ReadFull(r, head[:4]); err != nil {
+		return nil, err
+	}
+	msgLen := stnet.SdpLen(head)
+	headLen := 4
+	if head[0] == stnet.SdpFrameMagic {
+		if _, err := io.ReadFull(r, head[4:]); err != nil {
+			return nil, err
+		}
+		msgLen = stnet.SdpLen(head[4:])
+		headLen = stnet.SdpFrameHeadLen
+	}
+	if msgLen < uint32(headLen) || msgLen > stnet.MaxSdpFrameSize {
+		return nil, fmt.Errorf("invalid length %d", msgLen)
+	}
+	frame := make([]byte, msgLen)
+	copy(frame, head[:headLen])
+	if _, err := io.ReadFull(r, frame[headLen:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -66,6 +67,30 @@ func (config *Config) FloatSection(sec string, key string, def float64) float64
 	return config.float(m.values, key, def)
 }
 
+//Sections returns the names of the sections, sorted
+func (config *Config) Sections() []string {
+	names := make([]string, 0, len(config.sections))
+	for name := range config.sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//SectionKeys returns the keys of a section, sorted
+func (config *Config) SectionKeys(sec string) []string {
+	m, ok := config.sections[sec]
+	if !ok {
+		return nil
+	}
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 type configSection struct {
 	name   string
 	values map[string]string
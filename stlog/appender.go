@@ -0,0 +1,57 @@
+package stlog
+
+import (
+	"io"
+	"os"
+)
+
+//Appender is an output of a Logger, it is only called from the goroutine of the logger
+type Appender interface {
+	Append(rec *LogRecord) error
+	Close() error
+}
+
+type appenderEntry struct {
+	name     string
+	level    Level
+	appender Appender
+	conf     *AppenderConfig //nil if added in code
+}
+
+//WriterAppender formats records and writes them to W, Close closes W if it is an io.Closer
+type WriterAppender struct {
+	W      io.Writer
+	Format Formatter
+	buf    []byte
+}
+
+func NewWriterAppender(w io.Writer, f Formatter) *WriterAppender {
+	if f == nil {
+		f = TextFormatter
+	}
+	return &WriterAppender{W: w, Format: f}
+}
+
+func (a *WriterAppender) Append(rec *LogRecord) error {
+	a.buf = a.Format(a.buf[:0], rec)
+	_, err := a.W.Write(a.buf)
+	return err
+}
+
+func (a *WriterAppender) Close() error {
+	if c, ok := a.W.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type stdout struct{}
+
+func (stdout) Write(b []byte) (int, error) {
+	return os.Stdout.Write(b)
+}
+
+//NewTermAppender writes to os.Stdout, which is not closed with the appender
+func NewTermAppender(f Formatter) *WriterAppender {
+	return NewWriterAppender(stdout{}, f)
+}
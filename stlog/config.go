@@ -0,0 +1,471 @@
+package stlog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sstask/golib/stconfig"
+)
+
+//AppenderConfig declares an appender of a config file
+type AppenderConfig struct {
+	Name   string
+	Type   string //term, file, sock, net or syslog
+	Level  Level
+	Format string //text, json or logfmt
+
+	//text
+	TimeLayout string
+	UTC        bool
+	Caller     CallerFormat
+
+	//file
+	Filename      string
+	MaxSize       int
+	Daily         bool
+	Interval      time.Duration
+	Symlink       string
+	MaxBackup     int
+	MaxAge        time.Duration
+	Compress      bool
+	FlushInterval time.Duration
+	Sync          SyncPolicy
+	SyncInterval  time.Duration
+
+	//sock, net and syslog
+	Network   string
+	Addr      string
+	Framing   string //newline or octet
+	SpillPath string
+	Facility  int
+	App       string
+}
+
+//LogConfig is what LoadConfig reads.
+//Packages overrides the levels of all appenders for the records logged from a package and its subpackages
+type LogConfig struct {
+	Appenders []AppenderConfig
+	Packages  map[string]Level
+}
+
+func (c *AppenderConfig) formatter() (Formatter, error) {
+	switch strings.ToLower(c.Format) {
+	case "", "text":
+		if c.TimeLayout == "" && !c.UTC && c.Caller == CallerFunc {
+			return TextFormatter, nil
+		}
+		return NewTextFormatter(TextFormat{c.TimeLayout, c.UTC, c.Caller}), nil
+	case "json":
+		return JSONFormatter, nil
+	case "logfmt":
+		return LogfmtFormatter, nil
+	}
+	return nil, fmt.Errorf("stlog: unknown format %q", c.Format)
+}
+
+func (c *AppenderConfig) newAppender() (Appender, error) {
+	f, err := c.formatter()
+	if err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case "term":
+		return NewTermAppender(f), nil
+	case "file":
+		return NewFileAppenderOptions(c.Filename, FileOptions{
+			MaxSize:       int64(c.MaxSize),
+			Daily:         c.Daily,
+			Interval:      c.Interval,
+			Symlink:       c.Symlink,
+			MaxBackup:     c.MaxBackup,
+			MaxAge:        c.MaxAge,
+			Compress:      c.Compress,
+			FlushInterval: c.FlushInterval,
+			Sync:          c.Sync,
+			SyncInterval:  c.SyncInterval,
+			Format:        f,
+		})
+	case "sock":
+		return NewSockAppender(c.Addr, f)
+	case "net":
+		framing := FrameNewline
+		if c.Framing == "octet" {
+			framing = FrameOctetCount
+		}
+		return NewNetAppender(NetOptions{Network: c.Network, Addr: c.Addr, Format: f, Framing: framing, SpillPath: c.SpillPath})
+	case "syslog":
+		return NewSyslogAppender(c.Network, c.Addr, Facility(c.Facility), c.App)
+	}
+	return nil, fmt.Errorf("stlog: unknown appender type %q of %s", c.Type, c.Name)
+}
+
+//sameOutput is true if the appenders of a and b differ in the level only
+func sameOutput(a, b AppenderConfig) bool {
+	a.Level = b.Level
+	return a == b
+}
+
+//ParseINI reads a config like:
+//	[term]
+//	type = term
+//	level = INFO
+//	[file]
+//	type = file
+//	level = DEBUG
+//	; json, or text with timelayout, utc and caller = func, short, full or none
+//	format = json
+//	; a time pattern like log/server-%Y%m%d-%H.log goes here, not in interval
+//	filename = log/server.log
+//	maxsize = 10485760
+//	daily = true
+//	maxbackup = 30
+//	interval = 1h
+//	symlink = log/server.log
+//	maxage = 720h
+//	compress = true
+//	flushinterval = 200ms
+//	; always, never or the interval
+//	sync = 1s
+//	[remote]
+//	; or syslog with facility and app
+//	type = net
+//	network = tcp
+//	addr = 10.0.0.5:5140
+//	framing = octet
+//	spillpath = log/remote.spill
+//	[package]
+//	github.com/sstask/golib/stnet = WARNING
+//every section with a type is an appender named after the section,
+//comments take lines of their own: a ';' after a value is part of it
+func ParseINI(cfg *stconfig.Config) (*LogConfig, error) {
+	conf := &LogConfig{Packages: make(map[string]Level)}
+	for _, sec := range cfg.Sections() {
+		if sec == "package" {
+			for _, pkg := range cfg.SectionKeys(sec) {
+				lvl, err := ParseLevel(cfg.StringSection(sec, pkg, ""))
+				if err != nil {
+					return nil, err
+				}
+				conf.Packages[pkg] = lvl
+			}
+			continue
+		}
+		typ := cfg.StringSection(sec, "type", "")
+		if typ == "" {
+			continue
+		}
+		lvl, err := ParseLevel(cfg.StringSection(sec, "level", "DEBUG"))
+		if err != nil {
+			return nil, err
+		}
+		c := AppenderConfig{
+			Name:      sec,
+			Type:      typ,
+			Level:     lvl,
+			Format:    cfg.StringSection(sec, "format", "text"),
+			Filename:  cfg.StringSection(sec, "filename", ""),
+			MaxSize:   int(cfg.IntegerSection(sec, "maxsize", 0)),
+			Daily:     cfg.BooleanSection(sec, "daily", false),
+			MaxBackup: int(cfg.IntegerSection(sec, "maxbackup", 0)),
+			Symlink:   cfg.StringSection(sec, "symlink", ""),
+			Compress:  cfg.BooleanSection(sec, "compress", false),
+			Network:   cfg.StringSection(sec, "network", ""),
+			Addr:      cfg.StringSection(sec, "addr", ""),
+			Framing:   cfg.StringSection(sec, "framing", ""),
+			SpillPath: cfg.StringSection(sec, "spillpath", ""),
+			Facility:  int(cfg.IntegerSection(sec, "facility", int64(SyslogUser))),
+			App:       cfg.StringSection(sec, "app", ""),
+		}
+		err = c.parseOptions(func(key string) string { return cfg.StringSection(sec, key, "") })
+		if err != nil {
+			return nil, err
+		}
+		conf.Appenders = append(conf.Appenders, c)
+	}
+	return conf, nil
+}
+
+//ParseXml reads a config like:
+//	<stlog>
+//		<appender name="term" type="term" level="INFO" timelayout="15:04:05.000" caller="short"/>
+//		<appender name="file" type="file" level="DEBUG" format="json" filename="log/server.log" maxsize="10485760" daily="true" maxbackup="30"
+//			interval="1h" symlink="log/current.log" maxage="720h" compress="true" flushinterval="200ms" sync="1s"/>
+//		<package name="github.com/sstask/golib/stnet" level="WARNING"/>
+//	</stlog>
+func ParseXml(node *stconfig.XmlNode) (*LogConfig, error) {
+	conf := &LogConfig{Packages: make(map[string]Level)}
+	for _, n := range node.FindNodes("appender") {
+		lvl, err := ParseLevel(attrDefault(n, "level", "DEBUG"))
+		if err != nil {
+			return nil, err
+		}
+		daily, _ := strconv.ParseBool(attrDefault(n, "daily", "false"))
+		compress, _ := strconv.ParseBool(attrDefault(n, "compress", "false"))
+		c := AppenderConfig{
+			Name:      n.GetAttr("name"),
+			Type:      n.GetAttr("type"),
+			Level:     lvl,
+			Format:    attrDefault(n, "format", "text"),
+			Filename:  n.GetAttr("filename"),
+			MaxSize:   n.GetAttrI("maxsize"),
+			Daily:     daily,
+			MaxBackup: n.GetAttrI("maxbackup"),
+			Symlink:   n.GetAttr("symlink"),
+			Compress:  compress,
+			Network:   n.GetAttr("network"),
+			Addr:      n.GetAttr("addr"),
+			Framing:   n.GetAttr("framing"),
+			SpillPath: n.GetAttr("spillpath"),
+			Facility:  int(SyslogUser),
+			App:       n.GetAttr("app"),
+		}
+		if n.GetAttr("facility") != "" {
+			c.Facility = n.GetAttrI("facility")
+		}
+		if err = c.parseOptions(n.GetAttr); err != nil {
+			return nil, err
+		}
+		conf.Appenders = append(conf.Appenders, c)
+	}
+	for _, n := range node.FindNodes("package") {
+		lvl, err := ParseLevel(n.GetAttr("level"))
+		if err != nil {
+			return nil, err
+		}
+		conf.Packages[n.GetAttr("name")] = lvl
+	}
+	return conf, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+//parseOptions reads the text format, the durations and the sync policy: always, never or the interval of syncs
+func (c *AppenderConfig) parseOptions(get func(key string) string) (err error) {
+	c.TimeLayout = get("timelayout")
+	if utc := get("utc"); utc != "" {
+		if c.UTC, err = strconv.ParseBool(utc); err != nil {
+			return err
+		}
+	}
+	switch caller := get("caller"); strings.ToLower(caller) {
+	case "", "func":
+		c.Caller = CallerFunc
+	case "short":
+		c.Caller = CallerShort
+	case "full":
+		c.Caller = CallerFull
+	case "none":
+		c.Caller = CallerNone
+	default:
+		return fmt.Errorf("stlog: unknown caller format %q of %s", caller, c.Name)
+	}
+	if c.Interval, err = parseDuration(get("interval")); err != nil {
+		return err
+	}
+	if c.MaxAge, err = parseDuration(get("maxage")); err != nil {
+		return err
+	}
+	if c.FlushInterval, err = parseDuration(get("flushinterval")); err != nil {
+		return err
+	}
+	switch sync := get("sync"); strings.ToLower(sync) {
+	case "always":
+		c.Sync = SyncAlways
+	case "never":
+		c.Sync = SyncNever
+	default:
+		c.Sync = SyncPeriodic
+		c.SyncInterval, err = parseDuration(sync)
+	}
+	return err
+}
+
+func attrDefault(n *stconfig.XmlNode, name, def string) string {
+	if v := n.GetAttr(name); v != "" {
+		return v
+	}
+	return def
+}
+
+//ReadConfig loads an ini file, or an xml file if path ends with .xml
+func ReadConfig(path string) (*LogConfig, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".xml") {
+		node, err := stconfig.LoadXml(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParseXml(node)
+	}
+	cfg, err := stconfig.LoadINI(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseINI(cfg)
+}
+
+//LoadConfig reads path and applies it
+func (log *Logger) LoadConfig(path string) error {
+	conf, err := ReadConfig(path)
+	if err != nil {
+		return err
+	}
+	return log.ApplyConfig(conf)
+}
+
+//ApplyConfig adds or updates the appenders of conf and removes the ones a former config declared but conf does not,
+//appenders added in code are kept unless conf declares their names.
+//An appender whose output is unchanged is kept open and only gets the new level
+func (log *Logger) ApplyConfig(conf *LogConfig) error {
+	old := make(map[string]AppenderConfig)
+	log.do(func() {
+		for _, e := range log.appenders {
+			if e.conf != nil {
+				old[e.name] = *e.conf
+			}
+		}
+	})
+
+	opened := make(map[string]Appender)
+	for i := range conf.Appenders {
+		c := &conf.Appenders[i]
+		if o, ok := old[c.Name]; ok && sameOutput(o, *c) {
+			continue
+		}
+		a, err := c.newAppender()
+		if err != nil {
+			for _, a := range opened {
+				a.Close()
+			}
+			return err
+		}
+		opened[c.Name] = a
+	}
+
+	pkgs := make([]pkgLevel, 0, len(conf.Packages))
+	for pkg, lvl := range conf.Packages {
+		pkgs = append(pkgs, pkgLevel{strings.TrimSuffix(pkg, "/"), lvl})
+	}
+	//the longest package matches first
+	sort.Slice(pkgs, func(i, j int) bool { return len(pkgs[i].pkg) > len(pkgs[j].pkg) })
+
+	ok := log.do(func() {
+		declared := make(map[string]bool)
+		for i := range conf.Appenders {
+			c := conf.Appenders[i]
+			declared[c.Name] = true
+			entry := appenderEntry{c.Name, c.Level, opened[c.Name], &c}
+			found := false
+			for j, e := range log.appenders {
+				if e.name != c.Name {
+					continue
+				}
+				found = true
+				if entry.appender == nil {
+					entry.appender = e.appender
+				} else {
+					e.appender.Close()
+				}
+				log.appenders[j] = entry
+			}
+			if !found && entry.appender != nil {
+				log.appenders = append(log.appenders, entry)
+			}
+		}
+		kept := log.appenders[:0]
+		for _, e := range log.appenders {
+			if e.conf != nil && !declared[e.name] {
+				e.appender.Close()
+				continue
+			}
+			kept = append(kept, e)
+		}
+		log.appenders = kept
+		log.pkgLevels = pkgs
+	})
+	if !ok {
+		for _, a := range opened {
+			a.Close()
+		}
+	}
+	return nil
+}
+
+//WatchConfig loads path and loads it again whenever it changes until stop is called,
+//a config that fails to load is reported on stderr and the former one stays
+func (log *Logger) WatchConfig(path string, interval time.Duration) (stop func(), err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = log.LoadConfig(path); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	mtime, size := fi.ModTime(), fi.Size()
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-log.clos:
+				return
+			case <-ticker.C:
+				fi, err := os.Stat(path)
+				if err != nil || (fi.ModTime().Equal(mtime) && fi.Size() == size) {
+					continue
+				}
+				mtime, size = fi.ModTime(), fi.Size()
+				if err = log.LoadConfig(path); err != nil {
+					fmt.Fprintf(os.Stderr, "log config %s error: %s\n", path, err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(quit) }) }, nil
+}
+
+/****** package levels ******/
+
+type pkgLevel struct {
+	pkg   string
+	level Level
+}
+
+//packageOf returns the package of a Source like "github.com/a/b.(*T).F:12"
+func packageOf(source string) string {
+	slash := strings.LastIndexByte(source, '/')
+	if dot := strings.IndexByte(source[slash+1:], '.'); dot >= 0 {
+		return source[:slash+1+dot]
+	}
+	return source
+}
+
+//packageLevel returns the override for the package of source, if any
+func (log *logCore) packageLevel(source string) (Level, bool) {
+	if len(log.pkgLevels) == 0 {
+		return 0, false
+	}
+	pkg := packageOf(source)
+	for _, p := range log.pkgLevels {
+		if pkg == p.pkg || strings.HasPrefix(pkg, p.pkg+"/") {
+			return p.level, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,87 @@
+package stlog
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+var defaultLogger atomic.Pointer[Logger]
+
+//Default returns the logger of the package functions, a NewLogger made on first use unless SetDefault was called
+func Default() *Logger {
+	if log := defaultLogger.Load(); log != nil {
+		return log
+	}
+	log := NewLogger()
+	if !defaultLogger.CompareAndSwap(nil, log) {
+		log.Close()
+	}
+	return defaultLogger.Load()
+}
+
+//SetDefault replaces the logger of the package functions and returns the former one, which may be nil;
+//closing it is up to the caller
+func SetDefault(log *Logger) *Logger {
+	return defaultLogger.Swap(log)
+}
+
+//the package functions log to Default()
+func Debug(arg0 interface{}, args ...interface{}) {
+	Default().print(DEBUG, arg0, args...)
+}
+
+func Info(arg0 interface{}, args ...interface{}) {
+	Default().print(INFO, arg0, args...)
+}
+
+func Warn(arg0 interface{}, args ...interface{}) {
+	Default().print(WARNING, arg0, args...)
+}
+
+func Error(arg0 interface{}, args ...interface{}) {
+	Default().print(ERROR, arg0, args...)
+}
+
+func Critical(arg0 interface{}, args ...interface{}) {
+	Default().print(CRITICAL, arg0, args...)
+}
+
+func Debugw(msg string, kv ...interface{}) {
+	Default().printw(DEBUG, msg, kv...)
+}
+
+func Infow(msg string, kv ...interface{}) {
+	Default().printw(INFO, msg, kv...)
+}
+
+func Warnw(msg string, kv ...interface{}) {
+	Default().printw(WARNING, msg, kv...)
+}
+
+func Errorw(msg string, kv ...interface{}) {
+	Default().printw(ERROR, msg, kv...)
+}
+
+func Criticalw(msg string, kv ...interface{}) {
+	Default().printw(CRITICAL, msg, kv...)
+}
+
+func DebugCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	Default().printCtx(DEBUG, ctx, arg0, args...)
+}
+
+func InfoCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	Default().printCtx(INFO, ctx, arg0, args...)
+}
+
+func WarnCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	Default().printCtx(WARNING, ctx, arg0, args...)
+}
+
+func ErrorCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	Default().printCtx(ERROR, ctx, arg0, args...)
+}
+
+func CriticalCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	Default().printCtx(CRITICAL, ctx, arg0, args...)
+}
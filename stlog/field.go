@@ -0,0 +1,116 @@
+package stlog
+
+import (
+	"fmt"
+	"strconv"
+)
+
+//Field is a key value pair of a record, the value keeps its type for formatters
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+//F makes a field, fields are taken out of the args of any log call, the other args are formatted
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+const badKey = "!BADKEY"
+
+//toFields turns key value pairs into fields, a value without key gets the key "!BADKEY"
+func toFields(kv []interface{}) []Field {
+	fields := make([]Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i++ {
+		if f, ok := kv[i].(Field); ok {
+			fields = append(fields, f)
+			continue
+		}
+		key, ok := kv[i].(string)
+		if !ok || i+1 == len(kv) {
+			fields = append(fields, Field{badKey, kv[i]})
+			continue
+		}
+		fields = append(fields, Field{key, kv[i+1]})
+		i++
+	}
+	return fields
+}
+
+//splitArgs takes Field args out, the other args are left to the format
+func splitArgs(args []interface{}) ([]interface{}, []Field) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	var rest []interface{}
+	var fields []Field
+	for _, a := range args {
+		if f, ok := a.(Field); ok {
+			fields = append(fields, f)
+		} else {
+			rest = append(rest, a)
+		}
+	}
+	return rest, fields
+}
+
+//With returns a logger sharing the outputs of log, its records have the fields of log and kv
+func (log *Logger) With(kv ...interface{}) *Logger {
+	fields := append(log.fields[:len(log.fields):len(log.fields)], toFields(kv)...)
+	return &Logger{log.logCore, fields, log.name}
+}
+
+func needQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, c := range s {
+		if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case error:
+		return x.Error()
+	case fmt.Stringer:
+		return x.String()
+	}
+	return fmt.Sprint(v)
+}
+
+//appendFields appends " k=v" for each field, values with spaces, quotes or '=' are quoted
+func appendFields(b []byte, fields []Field) []byte {
+	for _, f := range fields {
+		b = append(b, ' ')
+		b = append(b, f.Key...)
+		b = append(b, '=')
+		//the common numbers without an allocation
+		switch x := f.Value.(type) {
+		case int:
+			b = strconv.AppendInt(b, int64(x), 10)
+			continue
+		case int64:
+			b = strconv.AppendInt(b, x, 10)
+			continue
+		case uint64:
+			b = strconv.AppendUint(b, x, 10)
+			continue
+		case bool:
+			b = strconv.AppendBool(b, x)
+			continue
+		}
+		s := fieldString(f.Value)
+		if needQuote(s) {
+			b = strconv.AppendQuote(b, s)
+		} else {
+			b = append(b, s...)
+		}
+	}
+	return b
+}
@@ -0,0 +1,57 @@
+package stlog
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+//lineAppender keeps the records in the logfmt format
+type lineAppender struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (a *lineAppender) Append(rec *LogRecord) error {
+	a.mutex.Lock()
+	a.lines = append(a.lines, string(LogfmtFormatter(nil, rec)))
+	a.mutex.Unlock()
+	return nil
+}
+
+func (a *lineAppender) Close() error { return nil }
+
+func TestFields(t *testing.T) {
+	log := NewLogger()
+	log.RemoveAppender("term")
+	a := &lineAppender{}
+	log.AddAppender("lines", DEBUG, a)
+
+	log.Info("conn closed %d", 7, F("err", "eof"))
+	log.Info("conn closed", 7)
+	log.Info("50% done")
+	log.Infow("login", "user", "bob", F("ip", "1.2.3.4"), "odd")
+	log.Infow("100% done")
+	log.With("req", 3).Info("retry %d", 2)
+	log.Close()
+
+	want := []string{
+		`msg="conn closed 7" err=eof`,
+		`msg="conn closed%!(EXTRA int=7)"`,
+		`msg="50% done"`,
+		`msg=login user=bob ip=1.2.3.4 !BADKEY=odd`,
+		`msg="100% done"`,
+		`msg="retry 2" req=3`,
+	}
+	if len(a.lines) != len(want) {
+		t.Fatalf("got %d records, want %d: %q", len(a.lines), len(want), a.lines)
+	}
+	for i, w := range want {
+		if got := a.lines[i]; !strings.HasSuffix(got, w+"\n") {
+			t.Errorf("record %d: got %q, want the suffix %q", i, got, w)
+		}
+		if !strings.Contains(a.lines[i], "stlog.TestFields:") {
+			t.Errorf("record %d: the source is not the caller: %q", i, a.lines[i])
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package stlog
+
+//lockFile does nothing where flock is missing, only one process should rotate a file there
+func lockFile(path string) (unlock func()) {
+	return func() {}
+}
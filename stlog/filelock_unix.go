@@ -0,0 +1,24 @@
+//go:build unix
+
+package stlog
+
+import (
+	"os"
+	"syscall"
+)
+
+//lockFile takes an exclusive flock of path, the processes logging to a file rotate it in turn
+func lockFile(path string) (unlock func()) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return func() {}
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return func() {}
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}
+}
@@ -1,119 +1,515 @@
 package stlog
 
 import (
+	"bufio"
+	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
-// This log writer sends output to a file
+//SyncPolicy says when a file appender calls fsync
+type SyncPolicy int
+
+const (
+	SyncPeriodic SyncPolicy = iota //at most every FileOptions.SyncInterval, checked at each flush
+	SyncAlways                     //after every record
+	SyncNever                      //left to the os
+)
+
+//FileOptions sets up a file appender; records are buffered and flushed every FlushInterval,
+//CRITICAL records are flushed and synced at once unless Sync is SyncNever
+type FileOptions struct {
+	MaxSize   int64         //rotate at size, 0 never
+	Daily     bool          //rotate when the day changes, the same as an Interval of 24h
+	Interval  time.Duration //rotate at each multiple of Interval from the local midnight, time.Hour for hourly
+	MaxBackup int           //logfiles kept with the current one, so MaxBackup-1 old ones (.001, .002, etc)
+	MaxAge    time.Duration //remove old logfiles modified before, 0 keeps them
+	Compress  bool          //gzip old logfiles in the background
+	Symlink   string        //path of a symlink to the current file
+
+	BufferSize    int
+	FlushInterval time.Duration
+	Sync          SyncPolicy
+	SyncInterval  time.Duration
+
+	Format Formatter
+}
+
+var DefaultFileOptions = FileOptions{
+	BufferSize:    64 * 1024,
+	FlushInterval: 200 * time.Millisecond,
+	Sync:          SyncPeriodic,
+	SyncInterval:  time.Second,
+	Format:        TextFormatter,
+}
+
+// This log writer sends output to a file, it is the "file" appender.
+// The name may have the placeholders %Y %m %d %H %M %S (and %% for %), then a new file
+// is opened for each Interval instead of renaming the old one to name.<time>.001.
+// Several processes may log to the same file: rotation is done under a lock file and
+// a writer reopens the file once another one has moved it
 type FileLogWriter struct {
+	Format Formatter
+	buf    []byte
+	opts   FileOptions
+
+	mutex sync.Mutex //the flush goroutine works with the file too
+
 	// The opened file
+	pattern  string
 	filename string
 	file     *os.File
+	writer   *bufio.Writer
+	lastSync time.Time
 
 	// Rotate at size
-	maxsize int64
 	cursize int64
 
-	// Rotate daily
-	daily          bool
-	daily_opendate int
+	// Rotate at time
+	interval time.Duration
+	period   time.Time //the start of the period of the file
+	next     time.Time //when to check for the next period
 
-	// Keep old logfiles (.001, .002, etc)
-	maxbackup int
+	compressing sync.WaitGroup
+	quit        chan struct{}
+	done        chan struct{}
 }
 
 func newFileLogWriter(fname string, maxsize int, daily int, maxbackup int) (*FileLogWriter, error) {
-	log, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
-	if err != nil {
+	opts := DefaultFileOptions
+	opts.MaxSize = int64(maxsize)
+	opts.Daily = daily > 0
+	opts.MaxBackup = maxbackup
+	return NewFileAppenderOptions(fname, opts)
+}
+
+//NewFileAppender writes records in the format f to fname, rotating it like SetFileLevel
+func NewFileAppender(fname string, maxsize, daily, maxbackup int, f Formatter) (*FileLogWriter, error) {
+	w, err := newFileLogWriter(fname, maxsize, daily, maxbackup)
+	if err == nil && f != nil {
+		w.Format = f
+	}
+	return w, err
+}
+
+//NewFileAppenderOptions writes records to fname, zero options take the values of DefaultFileOptions
+func NewFileAppenderOptions(fname string, opts FileOptions) (*FileLogWriter, error) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultFileOptions.BufferSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFileOptions.FlushInterval
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultFileOptions.SyncInterval
+	}
+	if opts.Format == nil {
+		opts.Format = TextFormatter
+	}
+	if opts.Daily && opts.Interval <= 0 {
+		opts.Interval = 24 * time.Hour
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = patternInterval(fname)
+	}
+
+	w := &FileLogWriter{
+		Format:   opts.Format,
+		opts:     opts,
+		pattern:  fname,
+		writer:   bufio.NewWriterSize(nil, opts.BufferSize),
+		lastSync: time.Now(),
+		interval: opts.Interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := w.open(time.Now()); err != nil {
 		return nil, err
 	}
+	go w.flushLoop()
+	return w, nil
+}
+
+func (w *FileLogWriter) isPattern() bool {
+	return strings.IndexByte(w.pattern, '%') >= 0
+}
+
+//open opens the file of the period of now
+func (w *FileLogWriter) open(now time.Time) error {
+	name := expandPattern(w.pattern, now)
+	if w.isPattern() {
+		os.MkdirAll(filepath.Dir(name), 0755)
+	}
+	fd, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
 
-	size, err := log.Seek(0, os.SEEK_END)
+	size, err := fd.Seek(0, io.SeekEnd)
 	if err != nil {
-		return nil, err
+		fd.Close()
+		return err
 	}
 
-	return &FileLogWriter{
-		filename:       fname,
-		file:           log,
-		maxsize:        int64(maxsize),
-		cursize:        size,
-		daily:          daily > 0,
-		daily_opendate: time.Now().Day(),
-		maxbackup:      maxbackup,
-	}, nil
+	w.filename = name
+	w.file = fd
+	w.writer.Reset(fd)
+	w.cursize = size
+	if w.interval > 0 {
+		w.period = periodStart(now, w.interval)
+		w.next = periodStart(w.period.Add(w.interval), w.interval)
+		if !w.next.After(w.period) {
+			//a day longer than 24h
+			w.next = w.period.Add(w.interval)
+		}
+	}
+	w.linkCurrent()
+	return nil
 }
 
-func (w *FileLogWriter) close() {
-	if w.file != nil {
-		w.file.Close()
+//linkCurrent points the symlink to the current file, replacing it in one rename
+func (w *FileLogWriter) linkCurrent() {
+	if w.opts.Symlink == "" {
+		return
+	}
+	target, err := filepath.Rel(filepath.Dir(w.opts.Symlink), w.filename)
+	if err != nil {
+		target, _ = filepath.Abs(w.filename)
+	}
+	tmp := w.opts.Symlink + ".tmp" + strconv.Itoa(os.Getpid())
+	os.Remove(tmp)
+	if err = os.Symlink(target, tmp); err == nil {
+		err = os.Rename(tmp, w.opts.Symlink)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		fmt.Fprintf(os.Stderr, "log file symlink error: %s\n", err)
 	}
 }
 
-func (w *FileLogWriter) write(msg string) error {
-	now := time.Now()
-	if (w.maxsize > 0 && w.cursize >= w.maxsize) ||
-		(w.daily && now.Day() != w.daily_opendate) {
-		if err := w.rotate(); err != nil {
-			return err
+func (w *FileLogWriter) flushLoop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-ticker.C:
+			w.mutex.Lock()
+			if err := w.flush(false); err != nil {
+				fmt.Fprintf(os.Stderr, "log file flush error: %s\n", err)
+			}
+			w.checkMoved()
+			w.mutex.Unlock()
 		}
 	}
+}
 
-	n, err := fmt.Fprint(w.file, msg)
+//checkMoved reopens the file if another process or logrotate moved it,
+//and takes the size the other writers of the file made
+func (w *FileLogWriter) checkMoved() {
+	if w.file == nil {
+		return
+	}
+	fi, err := w.file.Stat()
 	if err != nil {
+		return
+	}
+	cur, err := os.Stat(w.filename)
+	if err == nil && os.SameFile(fi, cur) {
+		w.cursize = fi.Size()
+		return
+	}
+	if err := w.reopen(); err != nil {
+		fmt.Fprintf(os.Stderr, "log file reopen error: %s\n", err)
+	}
+}
+
+//flush writes the buffer to the file and syncs it as the policy says, or at once if force
+func (w *FileLogWriter) flush(force bool) error {
+	if w.file == nil {
+		return nil
+	}
+	if err := w.writer.Flush(); err != nil {
 		return err
 	}
-	w.file.Sync()
+	switch w.opts.Sync {
+	case SyncNever:
+		return nil
+	case SyncPeriodic:
+		if !force && time.Since(w.lastSync) < w.opts.SyncInterval {
+			return nil
+		}
+	}
+	w.lastSync = time.Now()
+	return w.file.Sync()
+}
 
-	w.cursize += int64(n)
-	return nil
+//Flush writes the buffered records and syncs the file
+func (w *FileLogWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.flush(true)
 }
 
-func (w *FileLogWriter) rotate() error {
+func (w *FileLogWriter) reopen() error {
 	if w.file != nil {
+		w.flush(true)
 		w.file.Close()
+		w.file = nil
 	}
+	return w.open(time.Now())
+}
 
-	_, err := os.Lstat(w.filename)
-	if err == nil { // file exists
-		fname := w.filename
-		if w.daily {
-			if time.Now().Day() != w.daily_opendate {
-				yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-				fname = w.filename + fmt.Sprintf(".%s", yesterday)
-			} else {
-				fname = w.filename + fmt.Sprintf(".%s", time.Now().Format("2006-01-02"))
-			}
+//Reopen closes the file and opens it by name again, for logrotate moving it
+func (w *FileLogWriter) Reopen() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	select {
+	case <-w.quit:
+		return os.ErrClosed
+	default:
+	}
+	return w.reopen()
+}
+
+func (w *FileLogWriter) Close() error {
+	select {
+	case <-w.quit:
+		return nil
+	default:
+	}
+	close(w.quit)
+	<-w.done
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var err error
+	if w.file != nil {
+		err = w.flush(true)
+		if cerr := w.file.Close(); err == nil {
+			err = cerr
 		}
-		err = renameFiles(fname, w.maxbackup)
-		if err != nil {
-			return fmt.Errorf("Rotate: %s\n", err)
+		w.file = nil
+	}
+	w.compressing.Wait()
+	return err
+}
+
+func (w *FileLogWriter) Append(rec *LogRecord) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	select {
+	case <-w.quit:
+		return os.ErrClosed
+	default:
+	}
+	w.buf = w.Format(w.buf[:0], rec)
+	if err := w.write(w.buf); err != nil {
+		return err
+	}
+	if rec.Level >= CRITICAL || w.opts.Sync == SyncAlways {
+		return w.flush(true)
+	}
+	return nil
+}
+
+func (w *FileLogWriter) write(msg []byte) error {
+	now := time.Now()
+	if w.file == nil ||
+		(w.opts.MaxSize > 0 && w.cursize >= w.opts.MaxSize) ||
+		(w.interval > 0 && !now.Before(w.next) && periodStart(now, w.interval).After(w.period)) {
+		if err := w.rotate(now); err != nil {
+			return err
 		}
+	}
 
+	//a record goes to the file in one write, so the records of several processes do not mix
+	if len(msg) > w.writer.Available() && w.writer.Buffered() > 0 {
+		if err := w.writer.Flush(); err != nil {
+			return err
+		}
+	}
+	n, err := w.writer.Write(msg)
+	w.cursize += int64(n)
+	return err
+}
+
+func (w *FileLogWriter) rotate(now time.Time) error {
+	var opened os.FileInfo
+	if w.file != nil {
+		w.flush(true)
+		opened, _ = w.file.Stat()
 		w.file.Close()
-		err = os.Rename(w.filename, fname+".001")
-		if err != nil {
-			return fmt.Errorf("Rotate: %s\n", err)
+		w.file = nil
+	}
+	//renaming moves the files being compressed
+	w.compressing.Wait()
+
+	unlock := lockFile(w.lockPath())
+	defer unlock()
+
+	prev := w.filename
+	backup := ""
+	if expandPattern(w.pattern, now) == prev {
+		// rename the file unless another process did it
+		cur, err := os.Stat(prev)
+		if err == nil && opened != nil && os.SameFile(cur, opened) {
+			fname := prev
+			if w.interval > 0 && !w.isPattern() {
+				fname = prev + "." + w.period.Format(periodLayout(w.interval))
+			}
+			err = renameFiles(fname, w.opts.MaxBackup)
+			if err != nil {
+				return fmt.Errorf("Rotate: %s\n", err)
+			}
+
+			err = os.Rename(prev, fname+".001")
+			if err != nil {
+				return fmt.Errorf("Rotate: %s\n", err)
+			}
+			backup = fname + ".001"
 		}
+	} else {
+		// the name of the new period
+		backup = prev
 	}
 
 	// Open the log file
-	fd, err := os.OpenFile(w.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
-	if err != nil {
+	if err := w.open(now); err != nil {
 		return err
 	}
-	w.file = fd
+	w.cleanup(backup, opened)
+	return nil
+}
 
-	now := time.Now()
-	w.daily_opendate = now.Day()
+func (w *FileLogWriter) lockPath() string {
+	dir, base := filepath.Split(w.pattern)
+	return filepath.Join(dir, "."+strings.Replace(base, "%", "", -1)+".lock")
+}
 
-	w.cursize = 0
+//owns is true for the old logfiles of w in its dir
+func (w *FileLogWriter) owns(name string) bool {
+	if name == filepath.Base(w.filename) || name == filepath.Base(w.opts.Symlink) ||
+		name == filepath.Base(w.lockPath()) || strings.HasSuffix(name, ".tmp") {
+		return false
+	}
+	glob := patternGlob(filepath.Base(w.pattern))
+	if ok, _ := filepath.Match(glob, name); ok && w.isPattern() {
+		return true
+	}
+	ok, _ := filepath.Match(glob+".*", name)
+	return ok
+}
 
-	return nil
+//cleanup compresses the new backup and removes the expired ones in the background,
+//after the other processes had time to see the rotation; it holds the rotation lock
+//so another process can not rename the backups meanwhile
+func (w *FileLogWriter) cleanup(backup string, info os.FileInfo) {
+	if backup == "" || (!w.opts.Compress && w.opts.MaxAge <= 0) {
+		return
+	}
+	w.compressing.Add(1)
+	go func() {
+		defer w.compressing.Done()
+		if w.opts.Compress {
+			select {
+			case <-time.After(2 * w.opts.FlushInterval):
+			case <-w.quit:
+			}
+		}
+		unlock := lockFile(w.lockPath())
+		defer unlock()
+		if w.opts.Compress {
+			if backup = movedBackup(backup, info, w.opts.MaxBackup); backup != "" {
+				if err := compressFile(backup); err != nil && !os.IsNotExist(err) {
+					fmt.Fprintf(os.Stderr, "log file compress error: %s\n", err)
+				}
+			}
+		}
+		if w.opts.MaxAge > 0 {
+			removeExpired(filepath.Dir(w.pattern), w.owns, time.Now().Add(-w.opts.MaxAge))
+		}
+	}()
+}
+
+//movedBackup returns where the backup made of the file info is now, other processes may have
+//rotated since and moved name.001 to name.002 and so on; it is "" if the file is gone
+func movedBackup(backup string, info os.FileInfo, maxBackup int) string {
+	if info == nil {
+		return backup
+	}
+	if cur, err := os.Stat(backup); err == nil && os.SameFile(cur, info) {
+		return backup
+	}
+	if base := strings.TrimSuffix(backup, ".001"); base != backup {
+		for i := 2; i <= maxBackup; i++ {
+			name := fmt.Sprintf("%s.%03d", base, i)
+			if cur, err := os.Stat(name); err == nil && os.SameFile(cur, info) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+//compressFile writes name.gz and removes name
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz.tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(name+".gz.tmp", name+".gz")
+	}
+	if err != nil {
+		os.Remove(name + ".gz.tmp")
+		return err
+	}
+	in.Close()
+	return os.Remove(name)
+}
+
+//removeExpired removes the files of dir that owns accepts and were modified before deadline
+func removeExpired(dir string, owns func(name string) bool, deadline time.Time) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() || !owns(f.Name()) {
+			continue
+		}
+		info, err := f.Info()
+		if err == nil && info.ModTime().Before(deadline) {
+			os.Remove(filepath.Join(dir, f.Name()))
+		}
+	}
 }
 
+//renameFiles moves name.001 to name.002 and so on, compressed ones too, to free name.001;
+//maxFiles counts the current file, so maxFiles-1 backups are kept
 func renameFiles(name string, maxFiles int) error {
 	if maxFiles < 2 {
 		return nil
@@ -121,13 +517,139 @@ func renameFiles(name string, maxFiles int) error {
 	for i := maxFiles - 1; i > 1; i-- {
 		toPath := name + fmt.Sprintf(".%03d", i)
 		fromPath := name + fmt.Sprintf(".%03d", i-1)
-		if err := os.Rename(fromPath, toPath); err != nil && !os.IsNotExist(err) {
-			return err
+		for _, ext := range []string{"", ".gz"} {
+			if err := os.Rename(fromPath+ext, toPath+ext); err != nil && !os.IsNotExist(err) {
+				return err
+			}
 		}
 	}
+	return nil
+}
 
-	if err := os.Rename(name, name+".001"); err != nil && !os.IsNotExist(err) {
-		return err
+/****** time ******/
+
+//periodStart returns the start of the period of t, periods are counted from the local midnight
+//and from the first day of the year if d is days
+func periodStart(t time.Time, d time.Duration) time.Time {
+	y, m, day := t.Date()
+	midnight := time.Date(y, m, day, 0, 0, 0, 0, t.Location())
+	const oneDay = 24 * time.Hour
+	if d >= oneDay {
+		days := int(d / oneDay)
+		return midnight.AddDate(0, 0, -((t.YearDay() - 1) % days))
+	}
+	return midnight.Add(t.Sub(midnight) / d * d)
+}
+
+func periodLayout(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return "2006-01-02"
+	case d >= time.Hour:
+		return "2006-01-02-15"
+	}
+	return "2006-01-02-15-04"
+}
+
+var patternLayouts = map[byte]string{'Y': "2006", 'm': "01", 'd': "02", 'H': "15", 'M': "04", 'S': "05"}
+
+//expandPattern replaces %Y %m %d %H %M %S with the fields of t and %% with %
+func expandPattern(pattern string, t time.Time) string {
+	if strings.IndexByte(pattern, '%') < 0 {
+		return pattern
+	}
+	b := make([]byte, 0, len(pattern)+8)
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '%' || i+1 == len(pattern) {
+			b = append(b, c)
+			continue
+		}
+		i++
+		if layout, ok := patternLayouts[pattern[i]]; ok {
+			b = t.AppendFormat(b, layout)
+		} else {
+			b = append(b, pattern[i])
+		}
+	}
+	return string(b)
+}
+
+//patternInterval is the period of the smallest placeholder of a pattern, 0 if it has none
+func patternInterval(pattern string) time.Duration {
+	units := map[byte]time.Duration{'S': time.Second, 'M': time.Minute, 'H': time.Hour, 'd': 24 * time.Hour}
+	var d time.Duration
+	for i := 0; i+1 < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		i++
+		if u, ok := units[pattern[i]]; ok && (d == 0 || u < d) {
+			d = u
+		}
+	}
+	return d
+}
+
+//patternGlob turns the placeholders of a pattern into * for filepath.Match
+func patternGlob(pattern string) string {
+	b := make([]byte, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '%' && i+1 < len(pattern) && patternLayouts[pattern[i+1]] != "":
+			b = append(b, '*')
+			i++
+		case c == '*' || c == '?' || c == '[' || c == '\\':
+			b = append(b, '\\', c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+/****** reopen ******/
+
+//Reopen reopens the files of the appenders that have a Reopen method
+func (log *Logger) Reopen() {
+	log.do(func() {
+		for _, e := range log.appenders {
+			if r, ok := e.appender.(interface{ Reopen() error }); ok {
+				if err := r.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "log appender %s reopen error: %s\n", e.name, err)
+				}
+			}
+		}
+	})
+}
+
+//ReopenOnSignal calls Reopen on each of sigs, SIGHUP if none, until stop is called
+func (log *Logger) ReopenOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				log.Reopen()
+			case <-quit:
+				return
+			case <-log.clos:
+				signal.Stop(c)
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(quit)
+		})
 	}
-	return nil
 }
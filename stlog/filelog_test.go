@@ -0,0 +1,34 @@
+package stlog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileRotateMaxBackup(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewFileAppenderOptions(filepath.Join(dir, "x.log"), FileOptions{MaxSize: 100, MaxBackup: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		w.Append(&LogRecord{Level: INFO, Created: time.Now(), Message: strings.Repeat("m", 80)})
+	}
+	w.Close()
+
+	var names []string
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, f.Name())
+		}
+	}
+	//MaxBackup counts the current file
+	if want := []string{"x.log", "x.log.001", "x.log.002"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,254 @@
+package stlog
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+//Formatter appends the formatted record to b, records end with a newline
+type Formatter func(b []byte, rec *LogRecord) []byte
+
+//CallerFormat says how the text format writes the caller of a record
+type CallerFormat int
+
+const (
+	CallerFunc  CallerFormat = iota //package.Func:line
+	CallerShort                     //file.go:line
+	CallerFull                      //the path of the file and line
+	CallerNone
+)
+
+//TextFormat sets up a text formatter
+type TextFormat struct {
+	//a time layout, "000" after '.', ',' or ':' is the milliseconds
+	TimeLayout string
+	UTC        bool
+	Caller     CallerFormat
+}
+
+const DefaultTimeLayout = "2006-01-02 15:04:05:000 MST"
+
+//textTime is the time of a second formatted around the milliseconds
+type textTime struct {
+	sec    int64
+	prefix string
+	suffix string
+}
+
+type textFormatter struct {
+	TextFormat
+	prefix, suffix string //the layout around the milliseconds
+	millis         bool
+	cached         bool
+	cache          atomic.Pointer[textTime]
+}
+
+//NewTextFormatter returns a Formatter of time|level|source|message k=v;
+//the time of the last second is kept, formatters may be shared by loggers
+func NewTextFormatter(tf TextFormat) Formatter {
+	f := &textFormatter{TextFormat: tf}
+	if f.TimeLayout == "" {
+		f.TimeLayout = DefaultTimeLayout
+	}
+	f.prefix = f.TimeLayout
+	for i := 1; i+3 <= len(f.TimeLayout); i++ {
+		c := f.TimeLayout[i-1]
+		if (c == '.' || c == ',' || c == ':') && f.TimeLayout[i:i+3] == "000" &&
+			(i+3 == len(f.TimeLayout) || f.TimeLayout[i+3] != '0' && f.TimeLayout[i+3] != '9') {
+			f.prefix, f.suffix, f.millis = f.TimeLayout[:i], f.TimeLayout[i+3:], true
+			break
+		}
+	}
+	//fractions of a second left in the layout can not be cached
+	f.cached = true
+	for _, frac := range []string{".0", ".9", ",0", ",9"} {
+		if strings.Contains(f.prefix, frac) || strings.Contains(f.suffix, frac) {
+			f.cached = false
+		}
+	}
+	return f.format
+}
+
+func (f *textFormatter) appendTime(b []byte, t time.Time) []byte {
+	if f.UTC {
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+	if !f.cached {
+		return t.AppendFormat(b, f.TimeLayout)
+	}
+	sec := t.Unix()
+	tt := f.cache.Load()
+	if tt == nil || tt.sec != sec {
+		ts := t.Truncate(time.Second)
+		tt = &textTime{sec, ts.Format(f.prefix), ts.Format(f.suffix)}
+		f.cache.Store(tt)
+	}
+	b = append(b, tt.prefix...)
+	if f.millis {
+		ms := t.Nanosecond() / 1e6
+		b = append(b, byte('0'+ms/100), byte('0'+ms/10%10), byte('0'+ms%10))
+	}
+	return append(b, tt.suffix...)
+}
+
+func (f *textFormatter) appendCaller(b []byte, rec *LogRecord) []byte {
+	if rec.File == "" || f.Caller == CallerFunc {
+		if f.Caller == CallerNone {
+			return b
+		}
+		return append(b, rec.Source...)
+	}
+	switch f.Caller {
+	case CallerShort:
+		b = append(b, rec.File[strings.LastIndexByte(rec.File, '/')+1:]...)
+	case CallerFull:
+		b = append(b, rec.File...)
+	default:
+		return b
+	}
+	b = append(b, ':')
+	return strconv.AppendInt(b, int64(rec.Line), 10)
+}
+
+func (f *textFormatter) format(b []byte, rec *LogRecord) []byte {
+	b = f.appendTime(b, rec.Created)
+	b = append(b, '|')
+	b = append(b, rec.Level.String()...)
+	b = append(b, '|')
+	b = f.appendCaller(b, rec)
+	b = append(b, '|')
+	if rec.TraceID != "" {
+		b = append(b, "trace="...)
+		b = append(b, rec.TraceID...)
+		b = append(b, '|')
+	}
+	if rec.Logger != "" {
+		b = append(b, '[')
+		b = append(b, rec.Logger...)
+		b = append(b, "] "...)
+	}
+	b = append(b, rec.Message...)
+	b = appendFields(b, rec.Fields)
+	return append(b, '\n')
+}
+
+var defaultTextFormatter = NewTextFormatter(TextFormat{})
+
+//TextFormatter is the default text format: time|level|source|message k=v
+func TextFormatter(b []byte, rec *LogRecord) []byte {
+	return defaultTextFormatter(b, rec)
+}
+
+//JSONFormatter writes a json object per line, fields are members of it
+func JSONFormatter(b []byte, rec *LogRecord) []byte {
+	b = append(b, `{"time":"`...)
+	b = rec.Created.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, `","level":"`...)
+	b = append(b, rec.Level.String()...)
+	b = append(b, '"')
+	if rec.Logger != "" {
+		b = append(b, `,"logger":`...)
+		b = appendJSONString(b, rec.Logger)
+	}
+	b = append(b, `,"source":`...)
+	b = appendJSONString(b, rec.Source)
+	b = append(b, `,"msg":`...)
+	b = appendJSONString(b, rec.Message)
+	if rec.TraceID != "" {
+		b = append(b, `,"trace_id":`...)
+		b = appendJSONString(b, rec.TraceID)
+	}
+	for _, f := range rec.Fields {
+		b = append(b, ',')
+		b = appendJSONString(b, f.Key)
+		b = append(b, ':')
+		b = appendJSONValue(b, f.Value)
+	}
+	return append(b, "}\n"...)
+}
+
+//LogfmtFormatter writes time=... level=... source=... msg=... k=v
+func LogfmtFormatter(b []byte, rec *LogRecord) []byte {
+	b = append(b, "time="...)
+	b = rec.Created.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, " level="...)
+	b = append(b, rec.Level.String()...)
+	if rec.Logger != "" {
+		b = appendFields(b, []Field{{"logger", rec.Logger}})
+	}
+	b = appendFields(b, []Field{{"source", rec.Source}, {"msg", rec.Message}})
+	if rec.TraceID != "" {
+		b = appendFields(b, []Field{{"trace_id", rec.TraceID}})
+	}
+	b = appendFields(b, rec.Fields)
+	return append(b, '\n')
+}
+
+func appendJSONValue(b []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case string:
+		return appendJSONString(b, x)
+	case bool:
+		return strconv.AppendBool(b, x)
+	case int:
+		return strconv.AppendInt(b, int64(x), 10)
+	case int64:
+		return strconv.AppendInt(b, x, 10)
+	case int32:
+		return strconv.AppendInt(b, int64(x), 10)
+	case uint32:
+		return strconv.AppendUint(b, uint64(x), 10)
+	case uint64:
+		return strconv.AppendUint(b, x, 10)
+	case error:
+		return appendJSONString(b, x.Error())
+	case time.Duration:
+		return appendJSONString(b, x.String())
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return appendJSONString(b, fieldString(v))
+	}
+	return append(b, j...)
+}
+
+const hexDigits = "0123456789abcdef"
+
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				b = append(b, '\\', c)
+			case c == '\n':
+				b = append(b, '\\', 'n')
+			case c == '\r':
+				b = append(b, '\\', 'r')
+			case c == '\t':
+				b = append(b, '\\', 't')
+			case c < 0x20:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			default:
+				b = append(b, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, `�`...)
+		} else {
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(b, '"')
+}
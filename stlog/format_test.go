@@ -0,0 +1,72 @@
+package stlog
+
+import (
+	"testing"
+	"time"
+)
+
+func textRecord() *LogRecord {
+	return &LogRecord{
+		Level:   INFO,
+		Created: time.Date(2026, 10, 19, 8, 30, 15, 123456789, time.UTC),
+		Source:  "main.main:12",
+		Message: "server started",
+	}
+}
+
+func TestTextFormatterTime(t *testing.T) {
+	for _, tc := range []struct {
+		layout string
+		want   string
+	}{
+		{"", "2026-10-19 08:30:15:123 UTC|INFO|main.main:12|server started\n"},
+		{"15:04:05.000", "08:30:15.123|INFO|main.main:12|server started\n"},
+		{"15:04:05,000 MST", "08:30:15,123 UTC|INFO|main.main:12|server started\n"},
+		{"15:04:05.000000", "08:30:15.123456|INFO|main.main:12|server started\n"}, //not cached
+		{"15:04:05", "08:30:15|INFO|main.main:12|server started\n"},
+	} {
+		f := NewTextFormatter(TextFormat{TimeLayout: tc.layout, UTC: true})
+		rec := textRecord()
+		if got := string(f(nil, rec)); got != tc.want {
+			t.Errorf("layout %q: got %q, want %q", tc.layout, got, tc.want)
+		}
+		//the cached second must not leak into the next one
+		rec.Created = rec.Created.Add(time.Second + 7*time.Millisecond)
+		want := NewTextFormatter(TextFormat{TimeLayout: tc.layout, UTC: true})(nil, rec)
+		if got := f(nil, rec); string(got) != string(want) {
+			t.Errorf("layout %q next second: got %q, want %q", tc.layout, got, want)
+		}
+	}
+}
+
+func TestTextFormatterAllocs(t *testing.T) {
+	for _, tf := range []TextFormat{{}, {UTC: true}} {
+		f := NewTextFormatter(tf)
+		rec := textRecord()
+		b := f(nil, rec)
+		if n := testing.AllocsPerRun(100, func() { b = f(b[:0], rec) }); n != 0 {
+			t.Errorf("%+v: %v allocs per record", tf, n)
+		}
+	}
+}
+
+func BenchmarkTextFormatter(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		tf   TextFormat
+	}{
+		{"cached", TextFormat{}},
+		{"uncached", TextFormat{TimeLayout: "2006-01-02 15:04:05.000000 MST"}},
+		{"utc", TextFormat{UTC: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			f := NewTextFormatter(bc.tf)
+			rec := textRecord()
+			var buf []byte
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf = f(buf[:0], rec)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package stlog
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+//Named returns a logger sharing the outputs of log, named "name" or "parent.name" if log is named
+func (log *Logger) Named(name string) *Logger {
+	if log.name != "" {
+		name = log.name + "." + name
+	}
+	return &Logger{log.logCore, log.fields, name}
+}
+
+func (log *Logger) Name() string {
+	return log.name
+}
+
+func (log *logCore) levelMap() map[string]Level {
+	return log.levels.Load().(map[string]Level)
+}
+
+//SetLevel sets the level of the loggers named name and of their children without a level of their own,
+//"" is the root, which is DEBUG unless set
+func (log *Logger) SetLevel(name string, lvl Level) {
+	log.levelMutex.Lock()
+	defer log.levelMutex.Unlock()
+	old := log.levelMap()
+	levels := make(map[string]Level, len(old)+1)
+	for k, v := range old {
+		levels[k] = v
+	}
+	levels[name] = lvl
+	log.levels.Store(levels)
+}
+
+//ClearLevel makes the loggers named name take the level of their parent again
+func (log *Logger) ClearLevel(name string) {
+	log.levelMutex.Lock()
+	defer log.levelMutex.Unlock()
+	old := log.levelMap()
+	levels := make(map[string]Level, len(old))
+	for k, v := range old {
+		if k != name {
+			levels[k] = v
+		}
+	}
+	log.levels.Store(levels)
+}
+
+//Levels returns the levels set by SetLevel
+func (log *Logger) Levels() map[string]Level {
+	levels := make(map[string]Level)
+	for k, v := range log.levelMap() {
+		levels[k] = v
+	}
+	return levels
+}
+
+//Level returns the level of log, the one set for its name or the nearest parent name
+func (log *Logger) Level() Level {
+	levels := log.levelMap()
+	if len(levels) == 0 {
+		return DEBUG
+	}
+	name := log.name
+	for {
+		if lvl, ok := levels[name]; ok {
+			return lvl
+		}
+		if name == "" {
+			return DEBUG
+		}
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[:i]
+		} else {
+			name = ""
+		}
+	}
+}
+
+//IsEnabled is false if a record of lvl would be discarded by the level of log or by all appenders,
+//check it before building costly arguments
+func (log *Logger) IsEnabled(lvl Level) bool {
+	return lvl < CLOSE && lvl >= Level(atomic.LoadInt32(&log.minLevel)) && lvl >= log.Level()
+}
+
+//updateMinLevel runs on the goroutine of the logger after the appenders change
+func (log *logCore) updateMinLevel() {
+	min := CLOSE
+	for _, e := range log.appenders {
+		if e.level < min {
+			min = e.level
+		}
+	}
+	if min < CLOSE {
+		for _, p := range log.pkgLevels {
+			if p.level < min {
+				min = p.level
+			}
+		}
+	}
+	atomic.StoreInt32(&log.minLevel, int32(min))
+}
@@ -0,0 +1,375 @@
+package stlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Framing says how records are delimited on a stream connection, datagrams carry one record each
+type Framing int
+
+const (
+	FrameNewline    Framing = iota //the record and "\n"
+	FrameOctetCount                //"<length> <record>", RFC 6587
+)
+
+//NetOptions sets up a NetAppender
+type NetOptions struct {
+	Network string //tcp, udp, unix or unixgram
+	Addr    string
+	Format  Formatter
+	Framing Framing
+
+	BufferSize int    //records kept in memory while the server is down
+	SpillPath  string //a file for the records beyond BufferSize, if empty the oldest are dropped
+	SpillMax   int64  //bytes of the spill file, records beyond are dropped
+
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	Backoff      time.Duration //the first wait before dialing again, doubled after each failure
+	MaxBackoff   time.Duration
+}
+
+var DefaultNetOptions = NetOptions{
+	Network:      "tcp",
+	Format:       TextFormatter,
+	BufferSize:   10000,
+	SpillMax:     256 << 20,
+	DialTimeout:  5 * time.Second,
+	WriteTimeout: 5 * time.Second,
+	Backoff:      100 * time.Millisecond,
+	MaxBackoff:   10 * time.Second,
+}
+
+//NetAppender sends records to a server from its own goroutine, records wait in memory
+//and then in the spill file while it is unreachable, and are sent in order once it is back;
+//a record is done with once written to the connection, so on a stream the records written
+//just before the server dies may be lost with it: delivery is at most once
+type NetAppender struct {
+	Format Formatter
+	opts   NetOptions
+	buf    []byte
+
+	mutex    sync.Mutex
+	mem      [][]byte //framed records to send, the first is being sent
+	spill    *os.File
+	spillR   int64 //read and write offsets of the spill file
+	spillW   int64
+	spillOld int64 //end of the records a former process left, they may end torn
+	spilling bool  //new records go to the spill file until it is read up
+	dropped  uint64
+
+	conn   net.Conn
+	notify chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+func isDatagram(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+//NewNetAppender starts sending to opts.Addr, zero options take the values of DefaultNetOptions;
+//the server need not be up, only a spill file that can not be opened is an error
+func NewNetAppender(opts NetOptions) (*NetAppender, error) {
+	def := DefaultNetOptions
+	if opts.Network == "" {
+		opts.Network = def.Network
+	}
+	if opts.Format == nil {
+		opts.Format = def.Format
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = def.BufferSize
+	}
+	if opts.SpillMax <= 0 {
+		opts.SpillMax = def.SpillMax
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = def.DialTimeout
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = def.WriteTimeout
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = def.Backoff
+	}
+	if opts.MaxBackoff < opts.Backoff {
+		opts.MaxBackoff = def.MaxBackoff
+	}
+
+	a := &NetAppender{
+		Format: opts.Format,
+		opts:   opts,
+		notify: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if opts.SpillPath != "" {
+		os.MkdirAll(filepath.Dir(opts.SpillPath), 0755)
+		f, err := os.OpenFile(opts.SpillPath, os.O_RDWR|os.O_CREATE, 0660)
+		if err != nil {
+			return nil, err
+		}
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		//the records a former process left are sent first
+		a.spill, a.spillW, a.spillOld, a.spilling = f, size, size, size > 0
+	}
+	go a.run()
+	return a, nil
+}
+
+//NewSockAppender sends records in the format f to a tcp server, one per line
+func NewSockAppender(addr string, f Formatter) (*NetAppender, error) {
+	return NewNetAppender(NetOptions{Network: "tcp", Addr: addr, Format: f})
+}
+
+//Dropped returns the count of records lost because the buffers were full
+func (a *NetAppender) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+func (a *NetAppender) frame(rec []byte) []byte {
+	for len(rec) > 0 && rec[len(rec)-1] == '\n' {
+		rec = rec[:len(rec)-1]
+	}
+	if isDatagram(a.opts.Network) {
+		return append([]byte(nil), rec...)
+	}
+	if a.opts.Framing == FrameOctetCount {
+		msg := make([]byte, 0, len(rec)+8)
+		msg = strconv.AppendInt(msg, int64(len(rec)), 10)
+		msg = append(msg, ' ')
+		return append(msg, rec...)
+	}
+	msg := make([]byte, 0, len(rec)+1)
+	msg = append(msg, rec...)
+	return append(msg, '\n')
+}
+
+func (a *NetAppender) Append(rec *LogRecord) error {
+	a.buf = a.Format(a.buf[:0], rec)
+	msg := a.frame(a.buf)
+
+	a.mutex.Lock()
+	a.push(msg)
+	a.mutex.Unlock()
+
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+//push queues msg, the mutex is held
+func (a *NetAppender) push(msg []byte) {
+	if !a.spilling && len(a.mem) < a.opts.BufferSize {
+		a.mem = append(a.mem, msg)
+		return
+	}
+	if a.spill == nil {
+		//the first one may be half sent
+		if len(a.mem) > 1 {
+			copy(a.mem[1:], a.mem[2:])
+			a.mem = a.mem[:len(a.mem)-1]
+		}
+		a.mem = append(a.mem, msg)
+		atomic.AddUint64(&a.dropped, 1)
+		return
+	}
+	if a.spillW+int64(len(msg))+4 > a.opts.SpillMax {
+		atomic.AddUint64(&a.dropped, 1)
+		return
+	}
+	var head [4]byte
+	binary.BigEndian.PutUint32(head[:], uint32(len(msg)))
+	if _, err := a.spill.WriteAt(append(head[:], msg...), a.spillW); err != nil {
+		atomic.AddUint64(&a.dropped, 1)
+		return
+	}
+	a.spillW += int64(len(msg)) + 4
+	a.spilling = true
+}
+
+//readSpill moves the next spilled record to memory, the mutex is held
+func (a *NetAppender) readSpill() bool {
+	if !a.spilling {
+		return false
+	}
+	var head [4]byte
+	if _, err := a.spill.ReadAt(head[:], a.spillR); err == nil {
+		//a torn or garbled header is not a length to allocate
+		if n := int64(binary.BigEndian.Uint32(head[:])); n <= a.spillW-a.spillR-4 && n <= a.opts.SpillMax {
+			msg := make([]byte, n)
+			if _, err = a.spill.ReadAt(msg, a.spillR+4); err == nil {
+				a.spillR += n + 4
+				a.mem = append(a.mem, msg)
+				if a.spillR < a.spillW {
+					return true
+				}
+			}
+		}
+	}
+	if a.spillR < a.spillOld && a.spillOld < a.spillW {
+		//a torn record of a crash, the records of this process follow
+		a.spillR, a.spillOld = a.spillOld, 0
+		return a.readSpill()
+	}
+	//read up, or a torn record of a crash
+	a.spill.Truncate(0)
+	a.spillR, a.spillW, a.spillOld, a.spilling = 0, 0, 0, false
+	return len(a.mem) > 0
+}
+
+//next returns the record to send, it stays queued until pop
+func (a *NetAppender) next() ([]byte, bool) {
+	for {
+		a.mutex.Lock()
+		if len(a.mem) > 0 || a.readSpill() {
+			msg := a.mem[0]
+			a.mutex.Unlock()
+			return msg, true
+		}
+		a.mutex.Unlock()
+		select {
+		case <-a.notify:
+		case <-a.quit:
+			return nil, false
+		}
+	}
+}
+
+func (a *NetAppender) pop() {
+	a.mutex.Lock()
+	a.mem[0] = nil
+	a.mem = a.mem[1:]
+	if len(a.mem) == 0 {
+		a.mem = nil
+	}
+	a.mutex.Unlock()
+}
+
+func (a *NetAppender) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(a.opts.Network, a.opts.Addr, a.opts.DialTimeout)
+	if err != nil || isDatagram(a.opts.Network) {
+		return conn, err
+	}
+	//a server closing the connection is seen by the reads, before writes go to the dead socket
+	go func() {
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}()
+	return conn, nil
+}
+
+func (a *NetAppender) run() {
+	defer close(a.done)
+	backoff := a.opts.Backoff
+	for {
+		if a.conn == nil {
+			conn, err := a.dial()
+			if err != nil {
+				select {
+				case <-a.quit:
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > a.opts.MaxBackoff {
+					backoff = a.opts.MaxBackoff
+				}
+				continue
+			}
+			a.conn = conn
+			backoff = a.opts.Backoff
+		}
+
+		msg, ok := a.next()
+		if !ok {
+			return
+		}
+		a.conn.SetWriteDeadline(time.Now().Add(a.opts.WriteTimeout))
+		if _, err := a.conn.Write(msg); err != nil {
+			a.conn.Close()
+			a.conn = nil
+			continue
+		}
+		a.pop()
+	}
+}
+
+//Flush waits up to a second for the queued records to be sent
+func (a *NetAppender) Flush() error {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		a.mutex.Lock()
+		n := len(a.mem)
+		spilling := a.spilling
+		a.mutex.Unlock()
+		if n == 0 && !spilling {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return errors.New("stlog: net appender flush timeout")
+}
+
+//Close sends what it can in a second, the records still in memory go to the spill file
+func (a *NetAppender) Close() error {
+	select {
+	case <-a.quit:
+		return nil
+	default:
+	}
+	a.Flush()
+	close(a.quit)
+	<-a.done
+	if a.conn != nil {
+		a.conn.Close()
+	}
+	if a.spill == nil {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if len(a.mem) > 0 {
+		//before the records spilled already
+		rest := a.mem
+		a.mem = nil
+		var data []byte
+		var head [4]byte
+		for _, msg := range rest {
+			binary.BigEndian.PutUint32(head[:], uint32(len(msg)))
+			data = append(append(data, head[:]...), msg...)
+		}
+		if a.spilling {
+			old := make([]byte, a.spillW-a.spillR)
+			a.spill.ReadAt(old, a.spillR)
+			data = append(data, old...)
+		}
+		a.spill.Truncate(0)
+		a.spill.WriteAt(data, 0)
+	} else if a.spilling && a.spillR > 0 {
+		old := make([]byte, a.spillW-a.spillR)
+		a.spill.ReadAt(old, a.spillR)
+		a.spill.Truncate(0)
+		a.spill.WriteAt(old, 0)
+	}
+	return a.spill.Close()
+}
@@ -0,0 +1,191 @@
+package stlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//lineServer collects the lines sent to it until kill
+type lineServer struct {
+	ln    net.Listener
+	lines chan string
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func listenLines(t *testing.T, addr string) *lineServer {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &lineServer{ln: ln, lines: make(chan string, 100)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+			go func() {
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					s.lines <- sc.Text()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *lineServer) kill() {
+	s.ln.Close()
+	s.mutex.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.mutex.Unlock()
+}
+
+func (s *lineServer) expect(t *testing.T, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-s.lines:
+			if got != w {
+				t.Fatalf("got %q, want %q", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", w)
+		}
+	}
+}
+
+func messageFormatter(b []byte, rec *LogRecord) []byte {
+	return append(b, rec.Message...)
+}
+
+func appendMessages(a *NetAppender, prefix string, n int) []string {
+	var msgs []string
+	for i := 0; i < n; i++ {
+		msg := prefix + strconv.Itoa(i)
+		a.Append(&LogRecord{Level: INFO, Created: time.Now(), Message: msg})
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestNetAppenderReconnect(t *testing.T) {
+	srv := listenLines(t, "127.0.0.1:0")
+	addr := srv.ln.Addr().String()
+	spill := filepath.Join(t.TempDir(), "net.spill")
+	a, err := NewNetAppender(NetOptions{
+		Addr:       addr,
+		Format:     messageFormatter,
+		BufferSize: 4,
+		SpillPath:  spill,
+		Backoff:    10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	srv.expect(t, appendMessages(a, "a", 3)...)
+
+	srv.kill()
+	//records written before the appender sees the close are lost, delivery is at most once
+	time.Sleep(100 * time.Millisecond)
+	want := appendMessages(a, "b", 10)
+	if fi, err := os.Stat(spill); err != nil || fi.Size() == 0 {
+		t.Fatalf("records beyond BufferSize are not spilled: %v", err)
+	}
+
+	srv = listenLines(t, addr)
+	defer srv.kill()
+	srv.expect(t, want...)
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if d := a.Dropped(); d != 0 {
+		t.Fatalf("dropped %d", d)
+	}
+}
+
+func TestNetAppenderBadSpill(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		head    uint32 //of the record after r0 and r1
+		body    int
+		dropped uint64 //a spill file beyond SpillMax takes no record until read up
+	}{
+		{"torn", 0xfffffff0, 0, 0},
+		{"oversized", 100, 100, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := listenLines(t, "127.0.0.1:0")
+			addr := srv.ln.Addr().String()
+			srv.kill()
+			spill := filepath.Join(t.TempDir(), "net.spill")
+			var data []byte
+			var head [4]byte
+			for _, rec := range []string{"r0\n", "r1\n"} {
+				binary.BigEndian.PutUint32(head[:], uint32(len(rec)))
+				data = append(append(data, head[:]...), rec...)
+			}
+			binary.BigEndian.PutUint32(head[:], tc.head)
+			data = append(append(data, head[:]...), make([]byte, tc.body)...)
+			if err := os.WriteFile(spill, data, 0660); err != nil {
+				t.Fatal(err)
+			}
+
+			a, err := NewNetAppender(NetOptions{
+				Addr:      addr,
+				Format:    messageFormatter,
+				SpillPath: spill,
+				SpillMax:  64,
+				Backoff:   10 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.Close()
+			//spilled after the bad record while the server is down
+			want := []string{"r0", "r1"}
+			if c := appendMessages(a, "c", 2); tc.dropped == 0 {
+				want = append(want, c...)
+			}
+			srv = listenLines(t, addr)
+			defer srv.kill()
+			srv.expect(t, want...)
+			if err := a.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if d := a.Dropped(); d != tc.dropped {
+				t.Fatalf("dropped %d, want %d", d, tc.dropped)
+			}
+			srv.expect(t, appendMessages(a, "d", 2)...)
+		})
+	}
+}
+
+func TestSyslogFormatterFields(t *testing.T) {
+	f := SyslogFormatter(SyslogLocal0, "app")
+	rec := &LogRecord{Level: INFO, Created: time.Now(), Message: "hi", Fields: []Field{
+		F("a=b", 1), F("x]y", 2), F(`q"k`, 3), F("sp ace", "v]")}}
+	//PARAM-NAME has no '=', ']', '"' or space
+	want := `[fields@32473 a_b="1" x_y="2" q_k="3" sp_ace="v\]"] hi` + "\n"
+	if got := string(f(nil, rec)); !strings.HasSuffix(got, want) {
+		t.Fatalf("got %q, want the suffix %q", got, want)
+	}
+}
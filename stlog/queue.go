@@ -0,0 +1,140 @@
+package stlog
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+//OverflowPolicy says what a log call does when the queue of the logger is full
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota //wait for room
+	OverflowDropNewest                       //drop the record of the call
+	OverflowDropOldest                       //drop the oldest queued record to make room
+	OverflowDropBelow                        //drop the record if its level is below DropBelow, else wait
+)
+
+//QueueOptions sets the queue between the log calls and the goroutine of a logger
+type QueueOptions struct {
+	Size      int
+	Policy    OverflowPolicy
+	DropBelow Level
+}
+
+var DefaultQueueOptions = QueueOptions{Size: 1024, Policy: OverflowBlock}
+
+//DropReportInterval is how often a logger writes a warning with the count of records dropped since the last one
+var DropReportInterval = 10 * time.Second
+
+//SetOverflowPolicy changes the policy of a full queue, dropBelow is for OverflowDropBelow
+func (log *Logger) SetOverflowPolicy(policy OverflowPolicy, dropBelow Level) {
+	atomic.StoreInt32(&log.dropBelow, int32(dropBelow))
+	atomic.StoreInt32(&log.policy, int32(policy))
+}
+
+//Dropped returns the count of records dropped by the overflow policy
+func (log *Logger) Dropped() uint64 {
+	return atomic.LoadUint64(&log.dropped)
+}
+
+func (log *logCore) drop() {
+	atomic.AddUint64(&log.dropped, 1)
+}
+
+func (log *logCore) send(rec *LogRecord) {
+	select {
+	case log.recv <- rec:
+		return
+	case <-log.clos:
+		return
+	default:
+	}
+
+	switch OverflowPolicy(atomic.LoadInt32(&log.policy)) {
+	case OverflowDropNewest:
+		log.drop()
+		return
+	case OverflowDropOldest:
+		for {
+			select {
+			case log.recv <- rec:
+				return
+			case <-log.clos:
+				return
+			default:
+			}
+			select {
+			case <-log.recv:
+				log.drop()
+			default:
+			}
+		}
+	case OverflowDropBelow:
+		if rec.Level < Level(atomic.LoadInt32(&log.dropBelow)) {
+			log.drop()
+			return
+		}
+	}
+
+	select {
+	case log.recv <- rec:
+	case <-log.clos:
+	}
+}
+
+//Flush waits until the records logged before it are written and flushes the appenders that buffer
+func (log *Logger) Flush() {
+	for len(log.recv) > 0 {
+		select {
+		case <-log.wait:
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+	//the goroutine has taken the last record, do waits until it is written
+	log.do(func() {
+		for _, e := range log.appenders {
+			if f, ok := e.appender.(interface{ Flush() error }); ok {
+				if err := f.Flush(); err != nil {
+					fmt.Fprintf(os.Stderr, "log appender %s flush error: %s\n", e.name, err)
+				}
+			}
+		}
+	})
+}
+
+func (log *logCore) dispatch(rec *LogRecord) {
+	pkgLvl, override := log.packageLevel(rec.Source)
+	for _, e := range log.appenders {
+		lvl := e.level
+		if override && lvl != CLOSE {
+			lvl = pkgLvl
+		}
+		if lvl <= rec.Level {
+			if err := e.appender.Append(rec); err != nil {
+				fmt.Fprintf(os.Stderr, "log appender %s error: %s\n", e.name, err)
+			}
+		}
+	}
+}
+
+//reportDropped writes a warning if records were dropped since the last report,
+//it runs on the goroutine of the logger or after it ended
+func (log *logCore) reportDropped() {
+	dropped := atomic.LoadUint64(&log.dropped)
+	if dropped == log.reported {
+		return
+	}
+	n := dropped - log.reported
+	log.reported = dropped
+	log.dispatch(&LogRecord{
+		Level:   WARNING,
+		Created: time.Now(),
+		Source:  "stlog",
+		Message: fmt.Sprintf("dropped %d log records", n),
+		Fields:  []Field{{"dropped", n}, {"dropped_total", dropped}},
+	})
+}
@@ -0,0 +1,154 @@
+package stlog
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strconv"
+)
+
+func levelFromSlog(l slog.Level) Level {
+	switch {
+	case l < slog.LevelInfo:
+		return DEBUG
+	case l < slog.LevelWarn:
+		return INFO
+	case l < slog.LevelError:
+		return WARNING
+	case l < slog.LevelError+4:
+		return ERROR
+	}
+	return CRITICAL
+}
+
+func levelToSlog(l Level) slog.Level {
+	switch l {
+	case DEBUG:
+		return slog.LevelDebug
+	case INFO:
+		return slog.LevelInfo
+	case WARNING:
+		return slog.LevelWarn
+	case ERROR:
+		return slog.LevelError
+	}
+	return slog.LevelError + 4
+}
+
+//slogHandler sends slog records to a Logger
+type slogHandler struct {
+	log    *Logger
+	prefix string //of the keys, from WithGroup
+}
+
+//NewSlogHandler makes a slog.Handler writing to log, use slog.New(stlog.NewSlogHandler(log))
+func NewSlogHandler(log *Logger) slog.Handler {
+	return &slogHandler{log, ""}
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.log.IsEnabled(levelFromSlog(l))
+}
+
+func (h *slogHandler) appendAttr(fields []Field, prefix string, a slog.Attr) []Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = h.appendAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+	return append(fields, Field{prefix + a.Key, a.Value.Any()})
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var f runtime.Frame
+	src := ""
+	if r.PC != 0 {
+		fs := runtime.CallersFrames([]uintptr{r.PC})
+		f, _ = fs.Next()
+		src = f.Function + ":" + strconv.Itoa(f.Line)
+	}
+	fields := h.log.fields[:len(h.log.fields):len(h.log.fields)]
+	r.Attrs(func(a slog.Attr) bool {
+		fields = h.appendAttr(fields, h.prefix, a)
+		return true
+	})
+	h.log.send(&LogRecord{
+		Level:   levelFromSlog(r.Level),
+		Created: r.Time,
+		Source:  src,
+		File:    f.File,
+		Line:    f.Line,
+		Message: r.Message,
+		TraceID: traceIDFromContext(ctx),
+		Logger:  h.log.name,
+		Fields:  fields,
+	})
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields []Field
+	for _, a := range attrs {
+		fields = h.appendAttr(fields, h.prefix, a)
+	}
+	return &slogHandler{&Logger{h.log.logCore, append(h.log.fields[:len(h.log.fields):len(h.log.fields)], fields...), h.log.name}, h.prefix}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{h.log, h.prefix + name + "."}
+}
+
+//SetSlogLevel replaces the "slog" appender sending records at lvl or higher to h, CLOSE removes it
+func (log *Logger) SetSlogLevel(lvl Level, h slog.Handler) {
+	if lvl == CLOSE || h == nil {
+		log.RemoveAppender("slog")
+		return
+	}
+	log.AddAppender("slog", lvl, NewSlogAppender(h))
+}
+
+//SlogAppender hands records to a slog.Handler
+type SlogAppender struct {
+	H slog.Handler
+}
+
+func NewSlogAppender(h slog.Handler) *SlogAppender {
+	return &SlogAppender{h}
+}
+
+func (a *SlogAppender) Close() error {
+	return nil
+}
+
+func (a *SlogAppender) Append(rec *LogRecord) error {
+	h := a.H
+	l := levelToSlog(rec.Level)
+	if !h.Enabled(context.Background(), l) {
+		return nil
+	}
+	r := slog.NewRecord(rec.Created, l, rec.Message, 0)
+	for _, f := range rec.Fields {
+		r.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+	if rec.Logger != "" {
+		r.AddAttrs(slog.String("logger", rec.Logger))
+	}
+	if rec.Source != "" {
+		r.AddAttrs(slog.String("source", rec.Source))
+	}
+	if rec.TraceID != "" {
+		r.AddAttrs(slog.String("trace_id", rec.TraceID))
+	}
+	return h.Handle(context.Background(), r)
+}
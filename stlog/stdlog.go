@@ -0,0 +1,75 @@
+package stlog
+
+import (
+	stdlog "log"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//stdWriter turns the lines of a standard log.Logger into records
+type stdWriter struct {
+	log *Logger
+	lvl Level
+}
+
+func (w *stdWriter) Write(p []byte) (int, error) {
+	if !w.log.IsEnabled(w.lvl) {
+		if exitsAfterWrite() {
+			w.log.Flush()
+		}
+		return len(p), nil
+	}
+	//Write, log.(*Logger).output, log.Printf or the like, and its caller
+	pc, file, line, ok := runtime.Caller(3)
+	src := ""
+	if ok {
+		src = runtime.FuncForPC(pc).Name() + ":" + strconv.Itoa(line)
+	}
+	w.log.send(&LogRecord{
+		Level:   w.lvl,
+		Created: time.Now(),
+		Source:  src,
+		File:    file,
+		Line:    line,
+		Message: strings.TrimSuffix(string(p), "\n"),
+		Logger:  w.log.name,
+		Fields:  w.log.fields[:len(w.log.fields):len(w.log.fields)],
+	})
+	//log.Fatal exits and log.Panic may crash right after the write
+	if w.lvl >= CRITICAL || exitsAfterWrite() {
+		w.log.Flush()
+	}
+	return len(p), nil
+}
+
+//exitsAfterWrite tells if the log function calling Write is a Fatal or Panic one
+func exitsAfterWrite() bool {
+	//exitsAfterWrite, Write, log.(*Logger).output, the log function
+	pc, _, _, ok := runtime.Caller(3)
+	if !ok {
+		return false
+	}
+	name := strings.TrimPrefix(runtime.FuncForPC(pc).Name(), "log.")
+	name = strings.TrimPrefix(name, "(*Logger).")
+	return strings.HasPrefix(name, "Fatal") || strings.HasPrefix(name, "Panic")
+}
+
+//NewStdLogger returns a standard log.Logger whose lines are records of log at lvl,
+//for http.Server.ErrorLog and the like
+func NewStdLogger(log *Logger, lvl Level) *stdlog.Logger {
+	return stdlog.New(&stdWriter{log, lvl}, "", 0)
+}
+
+//RedirectStdLog sends the output of the standard log package to log at lvl, the time and caller
+//are those of the records; restore puts back the former output and flags
+func RedirectStdLog(log *Logger, lvl Level) (restore func()) {
+	out, flags := stdlog.Writer(), stdlog.Flags()
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(&stdWriter{log, lvl})
+	return func() {
+		stdlog.SetOutput(out)
+		stdlog.SetFlags(flags)
+	}
+}
@@ -6,11 +6,14 @@
 package stlog
 
 import (
+	"context"
 	"fmt"
-	"net"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,68 +33,101 @@ var (
 )
 
 func (l Level) String() string {
-	if l < 0 || int(l) > len(levelStrings) {
+	if l < 0 || int(l) >= len(levelStrings) {
 		return "UNKNOWN"
 	}
 	return levelStrings[int(l)]
 }
 
-/****** format ******/
-type formatCacheType struct {
-	LastUpdateMillSeconds int64
-	formatTime            string
+//ParseLevel accepts the names of the constants, their short names and OFF for CLOSE, in any case
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "DEBUG", "DEBG":
+		return DEBUG, nil
+	case "INFO":
+		return INFO, nil
+	case "WARNING", "WARN":
+		return WARNING, nil
+	case "ERROR", "EROR":
+		return ERROR, nil
+	case "CRITICAL", "CRIT":
+		return CRITICAL, nil
+	case "CLOSE", "OFF":
+		return CLOSE, nil
+	}
+	return CLOSE, fmt.Errorf("stlog: unknown level %q", s)
 }
 
-var formatCache = &formatCacheType{}
+/****** format ******/
 
+//FormatLogRecord returns the record in the format of TextFormatter
 func FormatLogRecord(rec *LogRecord) string {
 	if rec == nil {
 		return "<nil>"
 	}
-	secs := rec.Created.UnixNano() / 1e9
-	millSecs := rec.Created.UnixNano() / 1e6 % secs
-	cache := *formatCache
-	if cache.LastUpdateMillSeconds != millSecs {
-		month, day, year := rec.Created.Month(), rec.Created.Day(), rec.Created.Year()
-		hour, minute, second := rec.Created.Hour(), rec.Created.Minute(), rec.Created.Second()
-		zone, _ := rec.Created.Zone()
-		updated := &formatCacheType{
-			LastUpdateMillSeconds: millSecs,
-			formatTime:            fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d:%03d %s", year, month, day, hour, minute, second, millSecs, zone),
-		}
-		cache = *updated
-		formatCache = updated
-	}
-
-	return cache.formatTime + "|" + rec.Level.String() + "|" + rec.Source + "|" + rec.Message + "\n"
+	return string(TextFormatter(nil, rec))
 }
 
 type LogRecord struct {
 	Level   Level     // The log level
 	Created time.Time // The time at which the log message was created (nanoseconds)
-	Source  string    // The message source
-	Message string    // The log message
+	Source  string    // The message source, function:line
+	File    string    // The file and line of the caller, if known
+	Line    int
+	Message string  // The log message
+	TraceID string  // The trace of the context logged with, if any
+	Logger  string  // The name of the logger, see Named
+	Fields  []Field // The fields of the logger and of the call
+}
+
+var traceIDExtractor atomic.Value
+
+//SetTraceIDExtractor sets how the xxxCtx functions find the trace id of a context,
+//stnet sets it to stnet.TraceIDFromContext
+func SetTraceIDExtractor(fn func(ctx context.Context) string) {
+	traceIDExtractor.Store(fn)
+}
+
+func traceIDFromContext(ctx context.Context) string {
+	fn, _ := traceIDExtractor.Load().(func(ctx context.Context) string)
+	if fn == nil || ctx == nil {
+		return ""
+	}
+	return fn(ctx)
 }
 
+//Logger is a handle of the outputs, loggers made by With share them with their parent
 type Logger struct {
+	*logCore
+	fields []Field
+	name   string
+}
+
+type logCore struct {
 	recv chan *LogRecord
+	ctrl chan func()
 	clos chan int
 	wait chan int
 
-	term      Level
-	file      Level
-	sock      Level
-	sockip    string
-	conn      net.Conn
-	fileWrite *FileLogWriter
+	appenders []appenderEntry //only used by the goroutine of the logger
+	pkgLevels []pkgLevel      //overrides of the appender levels, longest first
+
+	levelMutex sync.Mutex
+	levels     atomic.Value //map[string]Level of the named loggers, copied on write
+	minLevel   int32        //the lowest level an appender takes
+
+	policy    int32 //OverflowPolicy
+	dropBelow int32
+	dropped   uint64
+	reported  uint64 //the dropped count of the last report
 }
 
-func (log *Logger) intLogf(lvl Level, format string, args ...interface{}) {
+func (log *Logger) intLogf(lvl Level, traceID string, fields []Field, format string, args ...interface{}) {
 	// Determine caller func
-	pc, _, lineno, ok := runtime.Caller(3)
+	pc, file, lineno, ok := runtime.Caller(4)
 	src := ""
 	if ok {
-		src = fmt.Sprintf("%s:%d", runtime.FuncForPC(pc).Name(), lineno)
+		src = runtime.FuncForPC(pc).Name() + ":" + strconv.Itoa(lineno)
 	}
 
 	msg := format
@@ -99,35 +135,58 @@ func (log *Logger) intLogf(lvl Level, format string, args ...interface{}) {
 		msg = fmt.Sprintf(format, args...)
 	}
 
+	if len(log.fields) > 0 {
+		fields = append(log.fields[:len(log.fields):len(log.fields)], fields...)
+	}
+
 	// Make the log record
 	rec := &LogRecord{
 		Level:   lvl,
 		Created: time.Now(),
 		Source:  src,
+		File:    file,
+		Line:    lineno,
 		Message: msg,
+		TraceID: traceID,
+		Logger:  log.name,
+		Fields:  fields,
 	}
-
-	for {
-		select {
-		case <-log.clos:
-			return
-		case log.recv <- rec:
-			return
-		case <-time.After(300 * time.Millisecond):
-			break
-		}
-	}
+	log.send(rec)
 }
 
 func (log *Logger) print(lvl Level, arg0 interface{}, args ...interface{}) {
+	log.printTrace(lvl, "", arg0, args...)
+}
+
+func (log *Logger) printCtx(lvl Level, ctx context.Context, arg0 interface{}, args ...interface{}) {
+	log.printTrace(lvl, traceIDFromContext(ctx), arg0, args...)
+}
+
+func (log *Logger) printTrace(lvl Level, traceID string, arg0 interface{}, args ...interface{}) {
+	if !log.IsEnabled(lvl) {
+		return
+	}
+	args, fields := splitArgs(args)
 	switch first := arg0.(type) {
 	case string:
 		// Use the string as a format string
-		log.intLogf(lvl, first, args...)
+		log.intLogf(lvl, traceID, fields, first, args...)
 	default:
 		// Build a format string so that it will be similar to Sprint
-		log.intLogf(lvl, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
+		log.intLogf(lvl, traceID, fields, fmt.Sprint(arg0)+strings.Repeat(" %v", len(args)), args...)
+	}
+}
+
+//printw logs msg as it is with the key value pairs kv
+func (log *Logger) printw(lvl Level, msg string, kv ...interface{}) {
+	log.printwTrace(lvl, "", msg, kv...)
+}
+
+func (log *Logger) printwTrace(lvl Level, traceID string, msg string, kv ...interface{}) {
+	if !log.IsEnabled(lvl) {
+		return
 	}
+	log.intLogf(lvl, traceID, toFields(kv), "%s", msg)
 }
 
 func (log *Logger) Debug(arg0 interface{}, args ...interface{}) {
@@ -165,37 +224,134 @@ func (log *Logger) Critical(arg0 interface{}, args ...interface{}) {
 	log.print(lvl, arg0, args...)
 }
 
+//the xxxw functions log a message that is not a format, followed by key value pairs or Fields;
+//Info and the like are printf style and take only Fields out of their args
+func (log *Logger) Debugw(msg string, kv ...interface{}) {
+	log.printw(DEBUG, msg, kv...)
+}
+
+func (log *Logger) Infow(msg string, kv ...interface{}) {
+	log.printw(INFO, msg, kv...)
+}
+
+func (log *Logger) Warnw(msg string, kv ...interface{}) {
+	log.printw(WARNING, msg, kv...)
+}
+
+func (log *Logger) Errorw(msg string, kv ...interface{}) {
+	log.printw(ERROR, msg, kv...)
+}
+
+func (log *Logger) Criticalw(msg string, kv ...interface{}) {
+	log.printw(CRITICAL, msg, kv...)
+}
+
+//the xxxCtx functions add the trace id of ctx to the record
+func (log *Logger) DebugCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	log.printCtx(DEBUG, ctx, arg0, args...)
+}
+
+func (log *Logger) InfoCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	log.printCtx(INFO, ctx, arg0, args...)
+}
+
+func (log *Logger) WarnCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	log.printCtx(WARNING, ctx, arg0, args...)
+}
+
+func (log *Logger) ErrorCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	log.printCtx(ERROR, ctx, arg0, args...)
+}
+
+func (log *Logger) CriticalCtx(ctx context.Context, arg0 interface{}, args ...interface{}) {
+	log.printCtx(CRITICAL, ctx, arg0, args...)
+}
+
+//Close writes the queued records and closes the appenders
 func (log *Logger) Close() {
-	rec := &LogRecord{
-		Level: CLOSE,
-	}
-	log.recv <- rec
+	log.Flush()
 	close(log.clos)
 	<-log.wait
+	log.reportDropped()
 
-	if log.fileWrite != nil {
-		log.fileWrite.close()
+	for _, e := range log.appenders {
+		e.appender.Close()
 	}
-	if log.conn != nil {
-		log.conn.Close()
+	log.appenders = nil
+}
+
+//do runs fn on the goroutine of the logger and waits for it
+func (log *Logger) do(fn func()) bool {
+	done := make(chan int)
+	select {
+	case log.ctrl <- func() { fn(); close(done) }:
+		<-done
+		return true
+	case <-log.clos:
+		return false
+	}
+}
+
+//AddAppender adds an output of records at lvl or higher, an appender of the same name is closed and replaced
+func (log *Logger) AddAppender(name string, lvl Level, a Appender) {
+	ok := log.do(func() {
+		for i, e := range log.appenders {
+			if e.name == name {
+				e.appender.Close()
+				log.appenders[i] = appenderEntry{name, lvl, a, nil}
+				return
+			}
+		}
+		log.appenders = append(log.appenders, appenderEntry{name, lvl, a, nil})
+	})
+	if !ok {
+		a.Close()
 	}
 }
 
+//RemoveAppender closes and removes the appender of name
+func (log *Logger) RemoveAppender(name string) {
+	log.do(func() {
+		for i, e := range log.appenders {
+			if e.name == name {
+				e.appender.Close()
+				log.appenders = append(log.appenders[:i], log.appenders[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+//SetAppenderLevel changes the level of the appender of name, it returns false if there is none
+func (log *Logger) SetAppenderLevel(name string, lvl Level) bool {
+	found := false
+	log.do(func() {
+		for i, e := range log.appenders {
+			if e.name == name {
+				log.appenders[i].level = lvl
+				found = true
+				return
+			}
+		}
+	})
+	return found
+}
+
+//SetTermLevel sets the level of the "term" appender, it is added with the text format if missing
 func (log *Logger) SetTermLevel(lvl Level) {
-	log.term = lvl
+	if !log.SetAppenderLevel("term", lvl) {
+		log.AddAppender("term", lvl, NewTermAppender(TextFormatter))
+	}
 }
 
+//SetFileLevel replaces the "file" appender, CLOSE removes it
 //param: maxsize int (the maxsize of single log file), daily int(is rotate daily), maxbackup int(max count of the backup log files)
 func (log *Logger) SetFileLevel(lvl Level, fname string, param ...int) {
-	log.file = lvl
 	if lvl == CLOSE {
-		if log.fileWrite != nil {
-			log.fileWrite.close()
-		}
+		log.RemoveAppender("file")
 		return
 	}
 
-	var err error
 	var maxsize, daily, maxbackup int
 	if len(param) > 0 {
 		maxsize = param[0]
@@ -206,78 +362,71 @@ func (log *Logger) SetFileLevel(lvl Level, fname string, param ...int) {
 	if len(param) > 2 {
 		maxbackup = param[2]
 	}
-	log.fileWrite, err = newFileLogWriter(fname, maxsize, daily, maxbackup)
+	w, err := newFileLogWriter(fname, maxsize, daily, maxbackup)
 	if err != nil {
-		fmt.Fprint(os.Stderr, "log file error: %s\n", err)
+		fmt.Fprintf(os.Stderr, "log file error: %s\n", err)
 		return
 	}
+	log.AddAppender("file", lvl, w)
 }
 
+//SetSockLevel replaces the "sock" appender, CLOSE removes it; records wait while the server is down
 func (log *Logger) SetSockLevel(lvl Level, serverip string) {
-	log.sock = lvl
-	log.sockip = serverip
 	if lvl == CLOSE {
-		if log.conn != nil {
-			log.conn.Close()
-		}
+		log.RemoveAppender("sock")
 		return
 	}
 
-	sock, err := net.Dial("tcp", serverip)
+	a, err := NewSockAppender(serverip, TextFormatter)
 	if err != nil {
-		fmt.Fprint(os.Stderr, "log server connect error(%q): %s\n", serverip, err)
+		fmt.Fprintf(os.Stderr, "log server error(%q): %s\n", serverip, err)
 		return
 	}
-	log.conn = sock
+	log.AddAppender("sock", lvl, a)
 }
 
+//NewLogger makes a logger with the "term" appender at DEBUG and DefaultQueueOptions
 func NewLogger() *Logger {
-	log := &Logger{
-		recv: make(chan *LogRecord, 16),
-		clos: make(chan int),
-		wait: make(chan int),
-		term: DEBUG,
-		file: CLOSE,
-		sock: CLOSE,
+	return NewLoggerQueue(DefaultQueueOptions)
+}
+
+func NewLoggerQueue(opts QueueOptions) *Logger {
+	if opts.Size <= 0 {
+		opts.Size = DefaultQueueOptions.Size
 	}
+	log := &logCore{
+		recv:      make(chan *LogRecord, opts.Size),
+		ctrl:      make(chan func()),
+		clos:      make(chan int),
+		wait:      make(chan int),
+		appenders: []appenderEntry{{"term", DEBUG, NewTermAppender(TextFormatter), nil}},
+	}
+
+	log.policy = int32(opts.Policy)
+	log.dropBelow = int32(opts.DropBelow)
 
 	go func() {
 		defer func() {
 			close(log.wait)
 		}()
 
+		report := time.NewTicker(DropReportInterval)
+		defer report.Stop()
 		for {
-			rec, ok := <-log.recv
-			if !ok || rec.Level == CLOSE {
+			select {
+			case <-log.clos:
 				return
-			}
-			msg := FormatLogRecord(rec)
-			if log.term <= rec.Level {
-				fmt.Fprint(os.Stdout, msg)
-			}
-
-			if log.file <= rec.Level {
-				err := log.fileWrite.write(msg)
-				if err != nil {
-					fmt.Fprint(os.Stderr, "log file write error: %s", err)
-				}
-			}
-
-			if log.sock <= rec.Level {
-				_, err := log.conn.Write([]byte(msg))
-				if err != nil {
-					fmt.Fprint(os.Stderr, "sock log send error(%q): %s", log.sockip, err)
-
-					log.conn, err = net.Dial("tcp", log.sockip)
-					if err != nil {
-						fmt.Fprint(os.Stderr, "log server connect error(%q): %s", log.sockip, err)
-						continue
-					}
-					log.conn.Write([]byte(msg))
-				}
+			case fn := <-log.ctrl:
+				fn()
+				log.updateMinLevel()
+			case <-report.C:
+				log.reportDropped()
+			case rec := <-log.recv:
+				log.dispatch(rec)
 			}
 		}
 	}()
 
-	return log
+	log.levels.Store(map[string]Level{})
+	return &Logger{logCore: log}
 }
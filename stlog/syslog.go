@@ -0,0 +1,134 @@
+package stlog
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Facility is the syslog facility of the records
+type Facility int
+
+const (
+	SyslogKern Facility = iota
+	SyslogUser
+	SyslogMail
+	SyslogDaemon
+	SyslogAuth
+	SyslogSyslog
+	SyslogLpr
+	SyslogNews
+	SyslogUucp
+	SyslogCron
+	SyslogAuthpriv
+	SyslogFtp
+	_
+	_
+	_
+	_
+	SyslogLocal0
+	SyslogLocal1
+	SyslogLocal2
+	SyslogLocal3
+	SyslogLocal4
+	SyslogLocal5
+	SyslogLocal6
+	SyslogLocal7
+)
+
+var syslogSeverity = [...]byte{DEBUG: 7, INFO: 6, WARNING: 4, ERROR: 3, CRITICAL: 2}
+
+//the structured data id of the fields, 32473 is the example enterprise number of RFC 5424
+const syslogSDID = "fields@32473"
+
+func syslogName(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	b := []byte(s)
+	for i, c := range b {
+		if c <= ' ' || c > '~' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	return string(b)
+}
+
+//sdNames replaces the bytes a PARAM-NAME can not have besides those of syslogName
+var sdNames = strings.NewReplacer("=", "_", "]", "_", `"`, "_")
+
+func appendSDValue(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', ']':
+			b = append(b, '\\', c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+//SyslogFormatter returns a Formatter of RFC 5424 messages; the logger name is the MSGID
+//and the trace id and fields are the structured data
+func SyslogFormatter(facility Facility, app string) Formatter {
+	if app == "" {
+		app = filepath.Base(os.Args[0])
+	}
+	host, _ := os.Hostname()
+	head := " " + syslogName(host, 255) + " " + syslogName(app, 48) + " " + strconv.Itoa(os.Getpid()) + " "
+	return func(b []byte, rec *LogRecord) []byte {
+		sev := byte(7)
+		if rec.Level >= 0 && int(rec.Level) < len(syslogSeverity) {
+			sev = syslogSeverity[rec.Level]
+		}
+		b = append(b, '<')
+		b = strconv.AppendInt(b, int64(facility)*8+int64(sev), 10)
+		b = append(b, ">1 "...)
+		b = rec.Created.AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+		b = append(b, head...)
+		b = append(b, syslogName(rec.Logger, 32)...)
+		b = append(b, ' ')
+		if rec.TraceID == "" && len(rec.Fields) == 0 {
+			b = append(b, '-')
+		} else {
+			b = append(b, "["+syslogSDID...)
+			if rec.TraceID != "" {
+				b = append(b, ` trace_id="`...)
+				b = appendSDValue(b, rec.TraceID)
+				b = append(b, '"')
+			}
+			for _, f := range rec.Fields {
+				b = append(b, ' ')
+				b = append(b, sdNames.Replace(syslogName(f.Key, 32))...)
+				b = append(b, `="`...)
+				b = appendSDValue(b, fieldString(f.Value))
+				b = append(b, '"')
+			}
+			b = append(b, ']')
+		}
+		b = append(b, ' ')
+		b = append(b, rec.Message...)
+		return append(b, '\n')
+	}
+}
+
+//NewSyslogAppender sends RFC 5424 messages to a syslog server over udp, tcp or unix,
+//to the local /dev/log if addr is empty; tcp uses octet counting
+func NewSyslogAppender(network, addr string, facility Facility, app string) (*NetAppender, error) {
+	if addr == "" {
+		network, addr = "unixgram", "/dev/log"
+	}
+	return NewNetAppender(NetOptions{
+		Network:      network,
+		Addr:         addr,
+		Format:       SyslogFormatter(facility, app),
+		Framing:      FrameOctetCount,
+		WriteTimeout: time.Second,
+	})
+}
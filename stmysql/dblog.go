@@ -0,0 +1,43 @@
+package stmysql
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/sstask/golib/stlog"
+)
+
+var dbLogger atomic.Pointer[stlog.Logger]
+
+//SetLogger logs the statements run by stmysql to log, at DEBUG with the time they took and at ERROR if they fail;
+//nothing is logged if nil, the default
+func SetLogger(log *stlog.Logger) {
+	dbLogger.Store(log)
+}
+
+func logSQL(sqlcmd string, start time.Time, err error) {
+	log := dbLogger.Load()
+	if log == nil {
+		return
+	}
+	if err != nil {
+		log.Errorw("sql error", "sql", sqlcmd, "err", err)
+	} else if log.IsEnabled(stlog.DEBUG) {
+		log.Debugw("sql", "sql", sqlcmd, "cost", time.Since(start).String())
+	}
+}
+
+func execSQL(db *sql.DB, sqlcmd string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := db.Exec(sqlcmd, args...)
+	logSQL(sqlcmd, start, err)
+	return res, err
+}
+
+func querySQL(db *sql.DB, sqlcmd string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.Query(sqlcmd, args...)
+	logSQL(sqlcmd, start, err)
+	return rows, err
+}
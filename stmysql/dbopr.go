@@ -7,6 +7,7 @@ import (
 )
 
 func GetTableColumns(db *sql.DB, table string) ([]string, error) {
+	//not logged, checkTable expects the error of a missing table
 	rows, err := db.Query("select * from " + table + " limit 1")
 	if err != nil {
 		return nil, err
@@ -164,7 +165,7 @@ func UpdateTable(db *sql.DB, table interface{}) ([]string, error) {
 	cmds := make([]string, 0, len(sqlstrs))
 	for _, v := range sqlstrs {
 		cmds = append(cmds, v)
-		_, err = db.Exec(v)
+		_, err = execSQL(db, v)
 		if err != nil {
 			return cmds, err
 		}
@@ -175,12 +176,12 @@ func UpdateTable(db *sql.DB, table interface{}) ([]string, error) {
 //db, err := sql.Open("mysql", "name:pwd@tcp(127.0.0.1:3306)/")
 func CreateDatabase(db *sql.DB, name string) error {
 	sqlstr := "CREATE DATABASE IF NOT EXISTS " + name
-	_, err := db.Exec(sqlstr)
+	_, err := execSQL(db, sqlstr)
 	if err != nil {
 		return err
 	}
 	sqlstr = "USE " + name
-	_, err = db.Exec(sqlstr)
+	_, err = execSQL(db, sqlstr)
 	if err != nil {
 		return err
 	}
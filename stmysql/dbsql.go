@@ -132,7 +132,7 @@ func replaceORinsertOne(db *sql.DB, table interface{}, cmd string) (sql.Result,
 	}
 	sqlcmd += ") " + sqlval + ")"
 
-	return db.Exec(sqlcmd, insertVals...)
+	return execSQL(db, sqlcmd, insertVals...)
 }
 
 //replace or insert a slice of structs into db
@@ -201,7 +201,7 @@ func replaceORinsertBatch(db *sql.DB, table interface{}, cmd string) (sql.Result
 	}
 
 	sqlcmd += sqlval
-	res, err := db.Exec(sqlcmd, insertVals...)
+	res, err := execSQL(db, sqlcmd, insertVals...)
 	return res, err
 }
 
@@ -226,9 +226,9 @@ func SelectOne(db *sql.DB, table interface{}, args ...interface{}) (int, error)
 		err  error
 	)
 	if len(args) > 1 {
-		rows, err = db.Query(sqlcmd, args[1:]...)
+		rows, err = querySQL(db, sqlcmd, args[1:]...)
 	} else {
-		rows, err = db.Query(sqlcmd)
+		rows, err = querySQL(db, sqlcmd)
 	}
 	if err != nil {
 		return 0, err
@@ -272,9 +272,9 @@ func SelectAll(db *sql.DB, table interface{}, args ...interface{}) ([]interface{
 		err  error
 	)
 	if len(args) > 1 {
-		rows, err = db.Query(sqlcmd, args[1:]...)
+		rows, err = querySQL(db, sqlcmd, args[1:]...)
 	} else {
-		rows, err = db.Query(sqlcmd)
+		rows, err = querySQL(db, sqlcmd)
 	}
 	if err != nil {
 		return nil, err
@@ -311,9 +311,9 @@ func GetRecordCount(db *sql.DB, table interface{}, args ...interface{}) (int, er
 		err  error
 	)
 	if len(args) > 1 {
-		rows, err = db.Query(sqlcmd, args[1:]...)
+		rows, err = querySQL(db, sqlcmd, args[1:]...)
 	} else {
-		rows, err = db.Query(sqlcmd)
+		rows, err = querySQL(db, sqlcmd)
 	}
 	if err != nil {
 		return 0, err
@@ -359,9 +359,9 @@ func GetRecordMax(db *sql.DB, table interface{}, column string, args ...interfac
 		err  error
 	)
 	if len(args) > 1 {
-		rows, err = db.Query(sqlcmd, args[1:]...)
+		rows, err = querySQL(db, sqlcmd, args[1:]...)
 	} else {
-		rows, err = db.Query(sqlcmd)
+		rows, err = querySQL(db, sqlcmd)
 	}
 	if err != nil {
 		return 0, err
@@ -418,9 +418,9 @@ func DeleteRecord(db *sql.DB, table interface{}, args ...interface{}) (sql.Resul
 	}
 
 	if len(args) > 1 {
-		return db.Exec(sqlcmd, args[1:]...)
+		return execSQL(db, sqlcmd, args[1:]...)
 	} else {
-		return db.Exec(sqlcmd)
+		return execSQL(db, sqlcmd)
 	}
 }
 
@@ -454,7 +454,7 @@ func UpdateRecord(db *sql.DB, table string, data map[string]interface{}, args ..
 			updateVals = append(updateVals, v)
 		}
 	}
-	return db.Exec(sqlcmd, updateVals...)
+	return execSQL(db, sqlcmd, updateVals...)
 }
 
 func UpdateRecordEx(db *sql.DB, table interface{}, args ...interface{}) (sql.Result, error) {
@@ -0,0 +1,131 @@
+package stnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+//Codec packs the params and results of rpc calls into ReqPayload and RspPayload,
+//the RequestPacket and ResponsePacket themselves are always sdp encoded
+type Codec interface {
+	Name() string
+	Marshal(vals []interface{}) ([]byte, error)
+	//Unmarshal returns values of types in order
+	Unmarshal(data []byte, types []reflect.Type) ([]reflect.Value, error)
+}
+
+//TypeChecker is implemented by codecs which can tell if they support a type,
+//rpc services check the params and results of their functions with it on registration
+type TypeChecker interface {
+	CheckType(t reflect.Type) error
+}
+
+//the codec name travels in RequestPacket.Context with this key, sdp is used if it is absent
+const RpcContextCodec = "codec"
+
+var (
+	codecs      = map[string]Codec{}
+	codecsMutex sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(SdpCodec{})
+	RegisterCodec(JsonCodec{})
+}
+
+//RegisterCodec makes a codec usable by rpc services and clients by its name
+func RegisterCodec(c Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	codecs[c.Name()] = c
+}
+
+func GetCodec(name string) Codec {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	return codecs[name]
+}
+
+func codecFromContext(ctx map[string]string) (Codec, error) {
+	name, ok := ctx[RpcContextCodec]
+	if !ok || name == "" {
+		return SdpCodec{}, nil
+	}
+	c := GetCodec(name)
+	if c == nil {
+		return nil, fmt.Errorf("unknown rpc codec:%s", name)
+	}
+	return c, nil
+}
+
+type SdpCodec struct{}
+
+func (SdpCodec) Name() string {
+	return "sdp"
+}
+func (SdpCodec) CheckType(t reflect.Type) error {
+	return sdpCheckType(t)
+}
+func (SdpCodec) Marshal(vals []interface{}) ([]byte, error) {
+	sdp := Sdp{}
+	for i, v := range vals {
+		err := sdp.pack(uint32(i+1), v, true, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sdp.buf, nil
+}
+func (SdpCodec) Unmarshal(data []byte, types []reflect.Type) ([]reflect.Value, error) {
+	sdp := Sdp{data, 0}
+	vals := make([]reflect.Value, len(types))
+	for i, t := range types {
+		val := newValByType(t)
+		e := sdp.unpack(val, true)
+		if e != nil {
+			return nil, e
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+//JsonCodec packs values as a json array
+type JsonCodec struct{}
+
+func (JsonCodec) Name() string {
+	return "json"
+}
+func (JsonCodec) CheckType(t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return errInvalidType
+	}
+	return nil
+}
+func (JsonCodec) Marshal(vals []interface{}) ([]byte, error) {
+	if vals == nil {
+		vals = []interface{}{}
+	}
+	return json.Marshal(vals)
+}
+func (JsonCodec) Unmarshal(data []byte, types []reflect.Type) ([]reflect.Value, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, err
+	}
+	if len(raws) < len(types) {
+		return nil, errNoEnoughData
+	}
+	vals := make([]reflect.Value, len(types))
+	for i, t := range types {
+		val := reflect.New(t)
+		if err := json.Unmarshal(raws[i], val.Interface()); err != nil {
+			return nil, err
+		}
+		vals[i] = val.Elem()
+	}
+	return vals, nil
+}
@@ -1,7 +1,6 @@
 package stnet
 
 import (
-	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,9 +11,10 @@ type Connector struct {
 	address         string
 	reconnectMSec   int //Millisecond
 	isclose         uint32
-	closeflag       bool
+	closeflag       uint32
 	sessCloseSignal chan int
 	wg              *sync.WaitGroup
+	endpoints       *endpointSet //set by NewResolverConnector
 }
 
 func NewConnector(address string, reconnectmsec int, msgparse MsgParse, UserData interface{}) (*Connector, error) {
@@ -33,6 +33,7 @@ func NewConnector(address string, reconnectmsec int, msgparse MsgParse, UserData
 		conn.sessCloseSignal <- 1
 	}, UserData)
 
+	conn.wg.Add(1)
 	go conn.connect()
 
 	return conn, nil
@@ -60,9 +61,17 @@ func NewConnectorNoStart(address string, reconnectmsec int, msgparse MsgParse, U
 }
 
 func (conn *Connector) connect() {
-	conn.wg.Add(1)
-	for !conn.closeflag {
-		cn, err := net.Dial("tcp", conn.address)
+	for atomic.LoadUint32(&conn.closeflag) == 0 {
+		address := conn.address
+		if conn.endpoints != nil {
+			address = conn.endpoints.next()
+		}
+		cn, err := dialAddress(address)
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics().Counter("stnet_connect_attempts_total", 1, "address", address, "result", result)
 		if err != nil {
 			if conn.reconnectMSec <= 0 {
 				break
@@ -89,15 +98,19 @@ func (cnt *Connector) IsConnected() bool {
 
 func (c *Connector) Start() {
 	if atomic.CompareAndSwapUint32(&c.isclose, 1, 0) {
+		c.wg.Add(1)
 		go c.connect()
 	}
 }
 
 func (c *Connector) Close() {
+	if c.endpoints != nil {
+		c.endpoints.stop()
+	}
 	if c.IsClose() {
 		return
 	}
-	c.closeflag = true
+	atomic.StoreUint32(&c.closeflag, 1)
 	c.Session.Close()
 	c.wg.Wait()
 }
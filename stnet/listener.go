@@ -21,7 +21,7 @@ func NewListener(address string, msgparse MsgParse) (*Listener, error) {
 		return nil, fmt.Errorf("MsgParse should not be nil")
 	}
 
-	ls, err := net.Listen("tcp", address)
+	ls, err := listenAddress(address)
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +50,7 @@ func NewListener(address string, msgparse MsgParse) (*Listener, error) {
 			})
 			lis.sessMap[sess.id] = sess
 			lis.sessMapMutex.Unlock()
+			metrics().Counter("stnet_sessions_accepted_total", 1, "listener", address)
 		}
 		lis.Close()
 	}()
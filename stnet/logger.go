@@ -0,0 +1,35 @@
+package stnet
+
+import (
+	"sync/atomic"
+
+	"github.com/sstask/golib/stlog"
+)
+
+var netLogger atomic.Pointer[stlog.Logger]
+
+//SetLogger sets where the errors of sessions and rpc calls go, stlog.Default() if nil;
+//services with a Log of their own use that
+func SetLogger(log *stlog.Logger) {
+	netLogger.Store(log)
+}
+
+//Logger returns the logger of SetLogger
+func Logger() *stlog.Logger {
+	if log := netLogger.Load(); log != nil {
+		return log
+	}
+	return stlog.Default()
+}
+
+//logError logs err of sess to log, or to Logger() if log is nil
+func logError(log *stlog.Logger, sess *Session, err error) {
+	if log == nil {
+		log = Logger()
+	}
+	if sess == nil {
+		log.Errorw("stnet error", "err", err)
+		return
+	}
+	log.Errorw("session error", "session", sess.GetID(), "err", err)
+}
@@ -0,0 +1,391 @@
+package stnet
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//MetricsSink receives the metrics of sessions, services, connects and rpc,
+//labels are pairs of name and value
+type MetricsSink interface {
+	Counter(name string, delta float64, labels ...string)
+	Gauge(name string, value float64, labels ...string)
+	Observe(name string, value float64, labels ...string) //a histogram sample
+}
+
+//MetricsResolver may be implemented by a sink to hand out series of fixed labels,
+//stnet resolves the series of its hot paths once and updates them without a lookup
+type MetricsResolver interface {
+	CounterOf(name string, labels ...string) func(delta float64)
+	GaugeOf(name string, labels ...string) func(value float64)
+	ObserverOf(name string, labels ...string) func(value float64)
+}
+
+//DefaultMetrics is the sink used unless SetMetricsSink is called, serve it with http.Handle("/metrics", stnet.DefaultMetrics)
+var DefaultMetrics = NewPromRegistry()
+
+type sinkHolder struct {
+	MetricsSink
+}
+
+var metricsSink atomic.Pointer[sinkHolder]
+
+func init() {
+	metricsSink.Store(&sinkHolder{DefaultMetrics})
+}
+
+//SetMetricsSink replaces the sink, nil discards all metrics
+func SetMetricsSink(s MetricsSink) {
+	if s == nil {
+		s = nullSink{}
+	}
+	metricsSink.Store(&sinkHolder{s})
+}
+
+func metrics() MetricsSink {
+	return metricsSink.Load().MetricsSink
+}
+
+const (
+	metricCounter = iota
+	metricGauge
+	metricObserver
+)
+
+//metric is a series of fixed labels, resolved again only when the sink is replaced
+type metric struct {
+	kind   int
+	name   string
+	labels []string
+	handle atomic.Pointer[metricHandle]
+}
+
+type metricHandle struct {
+	sink *sinkHolder
+	fn   func(float64)
+}
+
+func newMetric(kind int, name string, labels ...string) *metric {
+	return &metric{kind: kind, name: name, labels: labels}
+}
+
+func (m *metric) resolve(sink MetricsSink) func(float64) {
+	if r, ok := sink.(MetricsResolver); ok {
+		switch m.kind {
+		case metricCounter:
+			return r.CounterOf(m.name, m.labels...)
+		case metricGauge:
+			return r.GaugeOf(m.name, m.labels...)
+		}
+		return r.ObserverOf(m.name, m.labels...)
+	}
+	switch m.kind {
+	case metricCounter:
+		return func(v float64) { sink.Counter(m.name, v, m.labels...) }
+	case metricGauge:
+		return func(v float64) { sink.Gauge(m.name, v, m.labels...) }
+	}
+	return func(v float64) { sink.Observe(m.name, v, m.labels...) }
+}
+
+//Add adds to a counter, sets a gauge or observes a histogram sample
+func (m *metric) Add(v float64) {
+	sink := metricsSink.Load()
+	h := m.handle.Load()
+	if h == nil || h.sink != sink {
+		h = &metricHandle{sink, m.resolve(sink.MetricsSink)}
+		m.handle.Store(h)
+	}
+	h.fn(v)
+}
+
+type nullSink struct{}
+
+func (nullSink) Counter(name string, delta float64, labels ...string) {}
+func (nullSink) Gauge(name string, value float64, labels ...string)   {}
+func (nullSink) Observe(name string, value float64, labels ...string) {}
+
+/****** stnet metrics ******/
+
+var openSessions int64
+
+//why a session closed, the first reason set wins
+const (
+	closeByPeer uint32 = iota
+	closeByLocal
+	closeByWriteError
+	closeByReadError
+)
+
+var closeReasons = [...]string{"eof", "local", "write_error", "read_error"}
+
+var (
+	bytesInMetric     = newMetric(metricCounter, "stnet_bytes_in_total")
+	bytesOutMetric    = newMetric(metricCounter, "stnet_bytes_out_total")
+	messagesOutMetric = newMetric(metricCounter, "stnet_messages_out_total")
+	sendTimeoutMetric = newMetric(metricCounter, "stnet_send_timeout_total")
+	queueFullMetric   = newMetric(metricCounter, "stnet_send_queue_full_total")
+)
+
+//handlerMetrics are the series of a service or connect, handlers is only used by its loop
+type handlerMetrics struct {
+	name       string
+	queueDepth *metric
+	messagesIn *metric
+	handlers   map[uint32]*metric
+}
+
+func newHandlerMetrics(name string) *handlerMetrics {
+	return &handlerMetrics{
+		name:       name,
+		queueDepth: newMetric(metricGauge, "stnet_message_queue_depth", "name", name),
+		messagesIn: newMetric(metricCounter, "stnet_messages_in_total", "name", name),
+		handlers:   make(map[uint32]*metric),
+	}
+}
+
+func (hm *handlerMetrics) observeHandler(msgID uint32, start time.Time) {
+	m, ok := hm.handlers[msgID]
+	if !ok {
+		m = newMetric(metricObserver, "stnet_handler_seconds", "name", hm.name, "msgid", strconv.FormatUint(uint64(msgID), 10))
+		hm.handlers[msgID] = m
+	}
+	m.Add(time.Since(start).Seconds())
+}
+
+func sessionOpened() {
+	metrics().Gauge("stnet_sessions_open", float64(atomic.AddInt64(&openSessions, 1)))
+}
+
+func sessionClosed(reason uint32) {
+	m := metrics()
+	m.Gauge("stnet_sessions_open", float64(atomic.AddInt64(&openSessions, -1)))
+	m.Counter("stnet_sessions_closed_total", 1, "reason", closeReasons[reason])
+}
+
+//rpcMetrics reports calls to the sink, the interceptors wrap those of the options
+func rpcMetrics(side string) RpcObserver {
+	return func(req *RequestPacket, rsp *ResponsePacket, cost time.Duration) {
+		result := "ok"
+		if rsp != nil && rsp.MfwRet != 0 {
+			result = strconv.Itoa(int(rsp.MfwRet))
+		}
+		m := metrics()
+		method := rpcMethodName(req)
+		m.Counter("stnet_rpc_calls_total", 1, "side", side, "method", method, "result", result)
+		m.Observe("stnet_rpc_seconds", cost.Seconds(), "side", side, "method", method)
+	}
+}
+
+var (
+	rpcClientMetrics = rpcMetrics("client").ClientInterceptor()
+	rpcServerMetrics = rpcMetrics("server").ServerInterceptor()
+)
+
+/****** prometheus ******/
+
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type promSeries struct {
+	labels string
+	value  uint64 //float64 bits of a counter or gauge
+
+	mu     sync.Mutex //of a histogram
+	bounds []float64
+	counts []uint64 //of each bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (s *promSeries) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&s.value)
+		if atomic.CompareAndSwapUint64(&s.value, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (s *promSeries) set(value float64) {
+	atomic.StoreUint64(&s.value, math.Float64bits(value))
+}
+
+func (s *promSeries) observe(value float64) {
+	s.mu.Lock()
+	for i, b := range s.bounds {
+		if value <= b {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+	s.mu.Unlock()
+}
+
+type promFamily struct {
+	typ    string
+	series map[string]*promSeries
+}
+
+//PromRegistry keeps metrics in memory and writes them in the prometheus text format,
+//the series it resolves are updated without taking its lock
+type PromRegistry struct {
+	sync.Mutex
+	Buckets  []float64 //upper bounds of histogram buckets, DefaultBuckets if nil, set before the first Observe
+	families map[string]*promFamily
+}
+
+func NewPromRegistry() *PromRegistry {
+	return &PromRegistry{families: make(map[string]*promFamily)}
+}
+
+func escapeLabel(v string) string {
+	if !strings.ContainsAny(v, "\\\"\n") {
+		return v
+	}
+	v = strings.Replace(v, "\\", "\\\\", -1)
+	v = strings.Replace(v, "\"", "\\\"", -1)
+	return strings.Replace(v, "\n", "\\n", -1)
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(labels[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func (p *PromRegistry) series(name, typ string, labels []string) *promSeries {
+	key := formatLabels(labels)
+	p.Lock()
+	defer p.Unlock()
+	f, ok := p.families[name]
+	if !ok {
+		f = &promFamily{typ, make(map[string]*promSeries)}
+		p.families[name] = f
+	}
+	s, ok := f.series[key]
+	if !ok {
+		s = &promSeries{labels: key}
+		if typ == "histogram" {
+			s.bounds = p.buckets()
+			s.counts = make([]uint64, len(s.bounds))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (p *PromRegistry) buckets() []float64 {
+	if p.Buckets == nil {
+		return DefaultBuckets
+	}
+	return p.Buckets
+}
+
+func (p *PromRegistry) Counter(name string, delta float64, labels ...string) {
+	p.series(name, "counter", labels).add(delta)
+}
+
+func (p *PromRegistry) Gauge(name string, value float64, labels ...string) {
+	p.series(name, "gauge", labels).set(value)
+}
+
+func (p *PromRegistry) Observe(name string, value float64, labels ...string) {
+	p.series(name, "histogram", labels).observe(value)
+}
+
+func (p *PromRegistry) CounterOf(name string, labels ...string) func(delta float64) {
+	return p.series(name, "counter", labels).add
+}
+
+func (p *PromRegistry) GaugeOf(name string, labels ...string) func(value float64) {
+	return p.series(name, "gauge", labels).set
+}
+
+func (p *PromRegistry) ObserverOf(name string, labels ...string) func(value float64) {
+	return p.series(name, "histogram", labels).observe
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeSample(w *bufio.Writer, name, labels, extra, value string) {
+	w.WriteString(name)
+	if labels != "" || extra != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		if labels != "" && extra != "" {
+			w.WriteByte(',')
+		}
+		w.WriteString(extra)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+//WriteText writes all metrics in the prometheus text format, sorted by name and labels
+func (p *PromRegistry) WriteText(out io.Writer) error {
+	p.Lock()
+	defer p.Unlock()
+	w := bufio.NewWriter(out)
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := p.families[name]
+		w.WriteString("# TYPE " + name + " " + f.typ + "\n")
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.typ != "histogram" {
+				writeSample(w, name, s.labels, "", formatFloat(math.Float64frombits(atomic.LoadUint64(&s.value))))
+				continue
+			}
+			s.mu.Lock()
+			var cum uint64
+			for i, b := range s.bounds {
+				cum += s.counts[i]
+				writeSample(w, name+"_bucket", s.labels, `le="`+formatFloat(b)+`"`, strconv.FormatUint(cum, 10))
+			}
+			writeSample(w, name+"_bucket", s.labels, `le="+Inf"`, strconv.FormatUint(s.count, 10))
+			writeSample(w, name+"_sum", s.labels, "", formatFloat(s.sum))
+			writeSample(w, name+"_count", s.labels, "", strconv.FormatUint(s.count, 10))
+			s.mu.Unlock()
+		}
+	}
+	return w.Flush()
+}
+
+func (p *PromRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteText(w)
+}
@@ -0,0 +1,90 @@
+//pbcodec registers the "proto" rpc codec of stnet,
+//params and results of the rpc functions must be pointers of protobuf messages
+//	import _ "github.com/sstask/golib/stnet/pbcodec"
+//	rpc.SetCodec("proto")
+package pbcodec
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/sstask/golib/stnet"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+var errNotMessage = errors.New("value is not a proto.Message")
+
+var messageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+func init() {
+	stnet.RegisterCodec(Codec{})
+}
+
+//Codec packs the i-th value as a length delimited field numbered i+1
+type Codec struct{}
+
+func (Codec) Name() string {
+	return "proto"
+}
+
+func (Codec) CheckType(t reflect.Type) error {
+	if t.Kind() != reflect.Ptr || !t.Implements(messageType) {
+		return errNotMessage
+	}
+	return nil
+}
+
+func (Codec) Marshal(vals []interface{}) ([]byte, error) {
+	var b []byte
+	for i, v := range vals {
+		m, ok := v.(proto.Message)
+		if !ok {
+			return nil, errNotMessage
+		}
+		data, err := proto.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, protowire.Number(i+1), protowire.BytesType)
+		b = protowire.AppendBytes(b, data)
+	}
+	return b, nil
+}
+
+func (Codec) Unmarshal(data []byte, types []reflect.Type) ([]reflect.Value, error) {
+	vals := make([]reflect.Value, len(types))
+	for i, t := range types {
+		if t.Kind() != reflect.Ptr || !t.Implements(messageType) {
+			return nil, errNotMessage
+		}
+		vals[i] = reflect.New(t.Elem())
+	}
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		if i := int(num) - 1; i >= 0 && i < len(vals) {
+			if err := proto.Unmarshal(v, vals[i].Interface().(proto.Message)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return vals, nil
+}
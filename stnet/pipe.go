@@ -0,0 +1,201 @@
+package stnet
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//addresses starting with PipeScheme are served in process by net.Pipe,
+//so services and connects can be wired together in tests without tcp ports
+const PipeScheme = "pipe://"
+
+var (
+	ErrPipeNotFound = errors.New("pipe listener not found")
+	ErrPipeExists   = errors.New("pipe listener exists")
+	ErrPipeClosed   = errors.New("pipe listener closed")
+)
+
+//PipeFault changes the writes of the conns of a pipe listener, a write is a whole frame sent by a session
+type PipeFault struct {
+	Delay        time.Duration       //each write waits this long
+	DropToServer func(b []byte) bool //writes of the dialer it returns true for are dropped
+	DropToClient func(b []byte) bool //writes of the listener side it returns true for are dropped
+}
+
+type PipeListener struct {
+	name   string
+	accept chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+	fault  atomic.Value //PipeFault
+
+	connsMutex sync.Mutex
+	conns      map[*pipeConn]bool //the listener side of open conns
+}
+
+var (
+	pipes      = map[string]*PipeListener{}
+	pipesMutex sync.Mutex
+)
+
+//ListenPipe registers a pipe listener, it is reached with the address PipeScheme+name
+func ListenPipe(name string) (*PipeListener, error) {
+	pipesMutex.Lock()
+	defer pipesMutex.Unlock()
+	if _, ok := pipes[name]; ok {
+		return nil, ErrPipeExists
+	}
+	lis := &PipeListener{name: name, accept: make(chan net.Conn), closed: make(chan struct{}), conns: make(map[*pipeConn]bool)}
+	lis.fault.Store(PipeFault{})
+	pipes[name] = lis
+	return lis, nil
+}
+
+func findPipe(name string) (*PipeListener, error) {
+	pipesMutex.Lock()
+	defer pipesMutex.Unlock()
+	lis, ok := pipes[name]
+	if !ok {
+		return nil, ErrPipeNotFound
+	}
+	return lis, nil
+}
+
+//DialPipe connects to the pipe listener of name
+func DialPipe(name string) (net.Conn, error) {
+	lis, err := findPipe(name)
+	if err != nil {
+		return nil, err
+	}
+	client, server := net.Pipe()
+	sc := &pipeConn{server, lis, false}
+	select {
+	case lis.accept <- sc:
+		lis.connsMutex.Lock()
+		lis.conns[sc] = true
+		lis.connsMutex.Unlock()
+		return &pipeConn{client, lis, true}, nil
+	case <-lis.closed:
+		client.Close()
+		server.Close()
+		return nil, ErrPipeClosed
+	}
+}
+
+//SetPipeFault changes the writes of all conns of the pipe listener of name, PipeFault{} restores them
+func SetPipeFault(name string, f PipeFault) error {
+	lis, err := findPipe(name)
+	if err != nil {
+		return err
+	}
+	lis.fault.Store(f)
+	return nil
+}
+
+//DisconnectPipe closes all conns of the pipe listener of name, it keeps accepting new ones
+func DisconnectPipe(name string) error {
+	lis, err := findPipe(name)
+	if err != nil {
+		return err
+	}
+	lis.connsMutex.Lock()
+	conns := make([]*pipeConn, 0, len(lis.conns))
+	for c := range lis.conns {
+		conns = append(conns, c)
+	}
+	lis.connsMutex.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (lis *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-lis.accept:
+		return c, nil
+	case <-lis.closed:
+		return nil, ErrPipeClosed
+	}
+}
+
+func (lis *PipeListener) Close() error {
+	lis.once.Do(func() {
+		pipesMutex.Lock()
+		delete(pipes, lis.name)
+		pipesMutex.Unlock()
+		close(lis.closed)
+	})
+	return nil
+}
+
+func (lis *PipeListener) Addr() net.Addr {
+	return pipeAddr(lis.name)
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+func (a pipeAddr) String() string {
+	return PipeScheme + string(a)
+}
+
+type pipeConn struct {
+	net.Conn
+	lis    *PipeListener
+	dialer bool
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	f := c.lis.fault.Load().(PipeFault)
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	drop := f.DropToClient
+	if c.dialer {
+		drop = f.DropToServer
+	}
+	if drop != nil && drop(b) {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *pipeConn) Close() error {
+	if !c.dialer {
+		c.lis.connsMutex.Lock()
+		delete(c.lis.conns, c)
+		c.lis.connsMutex.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return pipeAddr(c.lis.name)
+}
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return pipeAddr(c.lis.name)
+}
+
+func listenAddress(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, PipeScheme) {
+		return ListenPipe(address[len(PipeScheme):])
+	}
+	return net.Listen("tcp", address)
+}
+
+func dialAddress(address string) (net.Conn, error) {
+	if address == "" {
+		return nil, ErrNoEndpoint
+	}
+	if strings.HasPrefix(address, PipeScheme) {
+		return DialPipe(address[len(PipeScheme):])
+	}
+	return net.Dial("tcp", address)
+}
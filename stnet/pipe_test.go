@@ -0,0 +1,150 @@
+package stnet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type pipeTestRpc struct {
+	release chan struct{}
+}
+
+func (r *pipeTestRpc) Add(a, b int32) int32 {
+	return a + b
+}
+
+func (r *pipeTestRpc) Div(a, b int32) (int32, error) {
+	if b == 0 {
+		return 0, NewRpcError(-1, "divided by zero")
+	}
+	return a / b, nil
+}
+
+func (r *pipeTestRpc) Count(n int32, stream *RpcStream) {
+	for i := int32(0); i < n; i++ {
+		stream.Send(i)
+	}
+}
+
+func (r *pipeTestRpc) Notify(ctx context.Context, msg string) {
+	RpcPush(RpcSession(ctx), "notice", msg)
+}
+
+func (r *pipeTestRpc) Hang() {
+	<-r.release
+}
+
+//startPipeRpc serves pipeTestRpc on pipe://name and returns a client of it, the client has its own loop
+func startPipeRpc(t *testing.T, name string) (*RPC, *pipeTestRpc, func()) {
+	imp := &pipeTestRpc{make(chan struct{})}
+	s := NewServer(name, 10)
+	if _, err := s.AddRpcService("srv", PipeScheme+name, imp, 1); err != nil {
+		t.Fatal(err)
+	}
+	rpc, err := s.AddRpcClient("cli", "srv", PipeScheme+name, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return rpc, imp, s.Stop
+}
+
+//call retries send until the client is connected
+func call(t *testing.T, send func() error) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := send()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func wait[T any](t *testing.T, ch chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	var zero T
+	return zero
+}
+
+func TestPipeRpc(t *testing.T) {
+	rpc, _, stop := startPipeRpc(t, "pipe-rpc")
+	defer stop()
+
+	sum := make(chan int32, 1)
+	call(t, func() error {
+		return rpc.SyncCallWithCallback("Add", int32(2), int32(3), func(r int32) { sum <- r })
+	})
+	if r := wait(t, sum); r != 5 {
+		t.Fatalf("Add: %d", r)
+	}
+
+	fail := make(chan *RpcError, 1)
+	call(t, func() error {
+		return rpc.SyncCallWithCallbackAndException("Div", int32(6), int32(0), func(r int32) { t.Errorf("Div: %d", r) },
+			ErrorHandler(func(e *RpcError) { fail <- e }))
+	})
+	if e := wait(t, fail); e == nil || e.Code != -1 || e.Msg != "divided by zero" {
+		t.Fatalf("Div: %v", e)
+	}
+
+	var got []int32
+	end := make(chan int32, 1)
+	call(t, func() error {
+		return rpc.StreamCall("Count", int32(3), func(v int32) { got = append(got, v) }, func(ret int32) { end <- ret })
+	})
+	if ret := wait(t, end); ret != SDPSERVERSUCCESS || len(got) != 3 || got[0] != 0 || got[2] != 2 {
+		t.Fatalf("Count: %d %v", ret, got)
+	}
+}
+
+func TestPipeRpcDisconnect(t *testing.T) {
+	rpc, imp, stop := startPipeRpc(t, "pipe-rpc-disconnect")
+	defer stop()
+	defer close(imp.release)
+
+	ret := make(chan int32, 1)
+	call(t, func() error {
+		return rpc.SyncCallWithException("Hang", func(r int32) { ret <- r })
+	})
+	time.Sleep(50 * time.Millisecond) //the request is at the server
+	if err := DisconnectPipe("pipe-rpc-disconnect"); err != nil {
+		t.Fatal(err)
+	}
+	if r := wait(t, ret); r != SDPCONNCLOSEDERR {
+		t.Fatalf("Hang: %d", r)
+	}
+}
+
+func TestPipeRpcPush(t *testing.T) {
+	rpc, _, stop := startPipeRpc(t, "pipe-rpc-push")
+	defer stop()
+
+	sum := make(chan int32, 1)
+	call(t, func() error {
+		return rpc.SyncCallWithCallback("Add", int32(1), int32(1), func(r int32) { sum <- r })
+	})
+	wait(t, sum)
+	//registered while connected, the pushes are handled on the loop of the client
+	notice := make(chan string, 1)
+	if err := rpc.RegisterPush("notice", func(msg string) { notice <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	call(t, func() error { return rpc.SyncCall("Notify", "hi") })
+	if msg := wait(t, notice); msg != "hi" {
+		t.Fatalf("push: %q", msg)
+	}
+}
@@ -0,0 +1,156 @@
+package stnet
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+//FileRegistry is a Registrar and Resolver keeping registrations in a json file,
+//it is for tests and single host deployments
+type FileRegistry struct {
+	path     string
+	Interval time.Duration
+	mutex    sync.Mutex
+}
+
+//name -> address -> expire time in unix nanoseconds
+type fileRegistryData map[string]map[string]int64
+
+func NewFileRegistry(path string) *FileRegistry {
+	return &FileRegistry{path: path}
+}
+
+func (r *FileRegistry) load() (fileRegistryData, error) {
+	data := make(fileRegistryData)
+	b, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return data, nil
+	}
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+//save writes a temp file and renames it, so readers never see a partial file
+func (r *FileRegistry) save(data fileRegistryData) error {
+	now := time.Now().UnixNano()
+	for name, addrs := range data {
+		for addr, expire := range addrs {
+			if expire < now {
+				delete(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			delete(data, name)
+		}
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+func (r *FileRegistry) Register(name, address string, ttl time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	data, err := r.load()
+	if err != nil {
+		return err
+	}
+	if data[name] == nil {
+		data[name] = make(map[string]int64)
+	}
+	data[name][address] = time.Now().Add(ttl).UnixNano()
+	return r.save(data)
+}
+
+func (r *FileRegistry) Deregister(name, address string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	data, err := r.load()
+	if err != nil {
+		return err
+	}
+	delete(data[name], address)
+	return r.save(data)
+}
+
+//Resolve returns the addresses of name whose registration has not expired
+func (r *FileRegistry) Resolve(name string) ([]string, error) {
+	r.mutex.Lock()
+	data, err := r.load()
+	r.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixNano()
+	addrs := []string{}
+	for addr, expire := range data[name] {
+		if expire >= now {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+func (r *FileRegistry) Watch(name string, fn func([]string)) (func(), error) {
+	return pollWatch(name, r.Resolve, r.Interval, fn)
+}
+
+var ErrInvalidTTL = errors.New("registration ttl must be positive")
+
+//KeepRegistered registers address for name and renews it every ttl/3, at most every millisecond,
+//until stop is called, which deregisters it
+func KeepRegistered(reg Registrar, name, address string, ttl time.Duration) (stop func(), err error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+	renew := ttl / 3
+	if renew < time.Millisecond {
+		renew = time.Millisecond
+	}
+	if err = reg.Register(name, address, ttl); err != nil {
+		return nil, err
+	}
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(renew)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				reg.Deregister(name, address)
+				return
+			case <-ticker.C:
+				reg.Register(name, address, ttl)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+			<-done
+		})
+	}, nil
+}
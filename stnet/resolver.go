@@ -0,0 +1,235 @@
+package stnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sstask/golib/stconfig"
+)
+
+var ErrNoEndpoint = errors.New("no endpoint")
+
+//Resolver turns a service name into the addresses serving it
+type Resolver interface {
+	Resolve(name string) ([]string, error)
+	//Watch calls fn with the addresses of name now and whenever they change, until stop is called
+	Watch(name string, fn func(addrs []string)) (stop func(), err error)
+}
+
+//Registrar is where services register themselves to be found by a Resolver,
+//a registration expires if it is not renewed in ttl
+type Registrar interface {
+	Register(name, address string, ttl time.Duration) error
+	Deregister(name, address string) error
+}
+
+//DefaultWatchInterval is how often resolvers without notifications look for changes
+var DefaultWatchInterval = time.Second
+
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//pollWatch implements Resolver.Watch by calling resolve every interval
+func pollWatch(name string, resolve func(string) ([]string, error), interval time.Duration, fn func([]string)) (func(), error) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	addrs, err := resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	fn(addrs)
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				now, err := resolve(name)
+				if err == nil && !sameAddrs(now, addrs) {
+					addrs = now
+					fn(addrs)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }, nil
+}
+
+func splitAddrs(s string) []string {
+	addrs := []string{}
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+/****** config file ******/
+
+//ConfigResolver reads addresses from an ini or xml file, it is reloaded when it changes.
+//ini: "[name]" with "addr = host:port,host:port"; xml: <service name="name" addr="host:port,host:port"/>
+type ConfigResolver struct {
+	path     string
+	Interval time.Duration
+
+	mutex sync.Mutex
+	mtime time.Time
+	ini   *stconfig.Config
+	xml   *stconfig.XmlNode
+}
+
+func NewConfigResolver(path string) *ConfigResolver {
+	return &ConfigResolver{path: path}
+}
+
+func (r *ConfigResolver) load() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	if (r.ini != nil || r.xml != nil) && fi.ModTime().Equal(r.mtime) {
+		return nil
+	}
+	if strings.HasSuffix(strings.ToLower(r.path), ".xml") {
+		node, err := stconfig.LoadXml(r.path)
+		if err != nil {
+			return err
+		}
+		r.xml = node
+	} else {
+		cfg, err := stconfig.LoadINI(r.path)
+		if err != nil {
+			return err
+		}
+		r.ini = cfg
+	}
+	r.mtime = fi.ModTime()
+	return nil
+}
+
+func (r *ConfigResolver) Resolve(name string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	if r.ini != nil {
+		return splitAddrs(r.ini.StringSection(name, "addr", "")), nil
+	}
+	for _, node := range r.xml.FindNodes("service") {
+		if node.GetAttr("name") == name {
+			return splitAddrs(node.GetAttr("addr")), nil
+		}
+	}
+	return []string{}, nil
+}
+
+func (r *ConfigResolver) Watch(name string, fn func([]string)) (func(), error) {
+	return pollWatch(name, r.Resolve, r.Interval, fn)
+}
+
+/****** dns srv ******/
+
+//DNSResolver looks up the SRV records of _Service._Proto.name, or of name if Service is empty
+type DNSResolver struct {
+	Service  string
+	Proto    string
+	Interval time.Duration
+}
+
+func (r *DNSResolver) Resolve(name string) ([]string, error) {
+	_, srvs, err := net.LookupSRV(r.Service, r.Proto, name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port)))
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+func (r *DNSResolver) Watch(name string, fn func([]string)) (func(), error) {
+	return pollWatch(name, r.Resolve, r.Interval, fn)
+}
+
+/****** connector ******/
+
+//endpointSet holds the addresses a Connector picks from in turn
+type endpointSet struct {
+	sync.Mutex
+	addrs   []string
+	idx     int
+	current string
+	stop    func()
+}
+
+func (es *endpointSet) next() string {
+	es.Lock()
+	defer es.Unlock()
+	if len(es.addrs) == 0 {
+		es.current = ""
+		return ""
+	}
+	es.current = es.addrs[es.idx%len(es.addrs)]
+	es.idx++
+	return es.current
+}
+
+//update returns true if the current address is gone
+func (es *endpointSet) update(addrs []string) bool {
+	es.Lock()
+	defer es.Unlock()
+	es.addrs = addrs
+	for _, a := range addrs {
+		if a == es.current {
+			return false
+		}
+	}
+	return es.current != ""
+}
+
+//NewResolverConnector connects to the addresses of name in turn,
+//it reconnects when the address it is connected to is gone
+func NewResolverConnector(r Resolver, name string, reconnectmsec int, msgparse MsgParse, UserData interface{}) (*Connector, error) {
+	conn, err := NewConnectorNoStart("", reconnectmsec, msgparse, UserData)
+	if err != nil {
+		return nil, err
+	}
+	es := &endpointSet{}
+	es.stop, err = r.Watch(name, func(addrs []string) {
+		if es.update(addrs) && conn.IsConnected() {
+			conn.Session.Close()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn.endpoints = es
+	conn.Start()
+	return conn, nil
+}
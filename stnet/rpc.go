@@ -1,10 +1,16 @@
 package stnet
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/sstask/golib/stlog"
 )
 
 const (
@@ -19,6 +25,9 @@ const (
 	SDPSERVEROVERLOAD     = -999997
 	SDPADAPTERNULL        = -999998
 	SDPRPCFUNCPARAMSEERR  = -999999
+	SDPRPCFUNCERR         = -999989 //the rpc function returned an error
+	SDPSERVERAUTHERR      = -999988 //rejected by RpcAuthServerInterceptor
+	SDPCONNCLOSEDERR      = -999987 //the connection dropped before the response arrived
 )
 
 var (
@@ -26,6 +35,7 @@ var (
 	ErrNoCallbackFunc = errors.New("no callback function or exception function")
 	ErrNoRpcFunc      = errors.New("no rpc function")
 	ErrRpcRspTimeOut  = errors.New("receive rsp but timeout")
+	ErrRpcConnClosed  = errors.New("rpc connection closed")
 )
 
 type RequestPacket struct {
@@ -43,36 +53,83 @@ type ResponsePacket struct {
 	RequestId  uint32
 	RspPayload string
 	Context    map[string]string
+	ErrMsg     string
+	PushName   string //not empty for messages pushed by the server
+	StreamSeq  uint32 //not zero for messages of a stream
+}
+
+//RpcError is the error returned by a rpc function or the failure of a call
+type RpcError struct {
+	Code int32
+	Msg  string
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func NewRpcError(code int32, msg string) *RpcError {
+	return &RpcError{code, msg}
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("rpc error(%d): %s", e.Code, e.Msg)
 }
 
 type RPC struct {
 	*Connect
-	rpcimp      *RPCImp
-	ServiceName string
-	ReqSequence uint32
+	rpcimp       *RPCImp
+	ServiceName  string
+	ReqSequence  uint32
+	codec        Codec
+	interceptors []ClientInterceptor
+	options      map[string]RpcMethodOption
+	defaultOpt   RpcMethodOption
 }
 
 type ExceptionHander = func(int32)
 
+//ErrorHandler can be used instead of ExceptionHander to get the message of the error
+type ErrorHandler = func(*RpcError)
+
+func toErrorHandler(x interface{}) (ErrorHandler, bool) {
+	switch h := x.(type) {
+	case ErrorHandler:
+		return h, true
+	case ExceptionHander:
+		return func(e *RpcError) {
+			if e == nil {
+				h(SDPSERVERSUCCESS)
+				return
+			}
+			h(e.Code)
+		}, true
+	}
+	return nil, false
+}
+
 type rpcRequest struct {
 	req       RequestPacket
 	callback  interface{}
-	exception ExceptionHander
-	timeout   int64
+	exception ErrorHandler
+	deadline  time.Time
+	codec     Codec
+	stream    interface{}
+	unacked   uint32
+	done      func(*ResponsePacket) //set for each send by the end of the interceptor chain
 }
 
 func (rpc *RPC) SyncCallWithCallbackAndException(funcName string, params ...interface{}) error { //the last two params should be callback function and exception function
 	if len(params) < 2 || reflect.TypeOf(params[len(params)-2]).Kind() != reflect.Func {
 		return ErrNoCallbackFunc
 	}
-	if _, ok := params[len(params)-1].(ExceptionHander); !ok {
+	exception, ok := toErrorHandler(params[len(params)-1])
+	if !ok {
 		return ErrNoCallbackFunc
 	}
 
 	rpcReq := rpcRequest{}
 	rpcReq.req.FuncName = funcName
 	rpcReq.callback = params[len(params)-2]
-	rpcReq.exception = params[len(params)-1].(ExceptionHander)
+	rpcReq.exception = exception
 	params = params[0 : len(params)-2]
 	return rpc.synccall(rpcReq, params...)
 }
@@ -94,14 +151,15 @@ func (rpc *RPC) SyncCallWithException(funcName string, params ...interface{}) er
 	if len(params) == 0 {
 		return ErrNoCallbackFunc
 	}
-	if _, ok := params[len(params)-1].(ExceptionHander); !ok {
+	exception, ok := toErrorHandler(params[len(params)-1])
+	if !ok {
 		return ErrNoCallbackFunc
 	}
 
 	rpcReq := rpcRequest{}
 	rpcReq.req.FuncName = funcName
 	rpcReq.callback = nil
-	rpcReq.exception = params[len(params)-1].(ExceptionHander)
+	rpcReq.exception = exception
 	params = params[0 : len(params)-1]
 	return rpc.synccall(rpcReq, params...)
 }
@@ -114,58 +172,200 @@ func (rpc *RPC) SyncCall(funcName string, params ...interface{}) error {
 	return rpc.synccall(rpcReq, params...)
 }
 
+//OneWayCall sends the call without waiting for a response
+func (rpc *RPC) OneWayCall(funcName string, params ...interface{}) error {
+	rpcReq := rpcRequest{}
+	rpcReq.req.FuncName = funcName
+	rpcReq.req.IsOneWay = true
+	return rpc.synccall(rpcReq, params...)
+}
+
+//StreamCall calls a server streaming function, the last two params should be the function handling
+//each message and the ErrorHandler or ExceptionHander called at the end of the stream,
+//with nil or SDPSERVERSUCCESS if the stream ends normally
+func (rpc *RPC) StreamCall(funcName string, params ...interface{}) error {
+	if len(params) < 2 || reflect.TypeOf(params[len(params)-2]).Kind() != reflect.Func {
+		return ErrNoCallbackFunc
+	}
+	end, ok := toErrorHandler(params[len(params)-1])
+	if !ok {
+		return ErrNoCallbackFunc
+	}
+
+	rpcReq := rpcRequest{}
+	rpcReq.req.FuncName = funcName
+	rpcReq.stream = params[len(params)-2]
+	rpcReq.exception = end
+	params = params[0 : len(params)-2]
+	return rpc.synccall(rpcReq, params...)
+}
+
+//RegisterPush sets the function handling messages pushed by RpcPush with name, it may be called while connected
+func (rpc *RPC) RegisterPush(name string, handler interface{}) error {
+	if handler == nil || reflect.TypeOf(handler).Kind() != reflect.Func {
+		return ErrNoPushFunc
+	}
+	rpc.rpcimp.Lock()
+	rpc.rpcimp.pushes[name] = handler
+	rpc.rpcimp.Unlock()
+	return nil
+}
+
+//a context.Context may be passed before the params of a call, it is given to the interceptors
 func (rpc *RPC) synccall(rpcReq rpcRequest, params ...interface{}) error {
-	rpcReq.timeout = time.Now().Unix() + 5
+	ctx := context.Background()
+	if len(params) > 0 {
+		if c, ok := params[0].(context.Context); ok {
+			ctx = c
+			params = params[1:]
+		}
+	}
+	opt := rpc.methodOption(rpcReq.req.FuncName)
 	rpcReq.req.ServiceName = rpc.ServiceName
-	rpcReq.req.RequestId = rpc.ReqSequence
+	rpcReq.req.RequestId = rpc.nextSequence()
+	rpcReq.req.Timeout = uint32(opt.Timeout / time.Millisecond)
+	rpcReq.req.Context = make(map[string]string)
+	rpcReq.codec = rpc.codec
+	if rpc.codec.Name() != (SdpCodec{}).Name() {
+		rpcReq.req.Context[RpcContextCodec] = rpc.codec.Name()
+	}
 
-	sdp := Sdp{}
-	for i, v := range params {
-		err := sdp.pack(uint32(i+1), v, true, true)
-		if err != nil {
-			return fmt.Errorf("wrong params in synccall:%s", err.Error())
+	payload, err := rpc.codec.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("wrong params in synccall:%s", err.Error())
+	}
+	rpcReq.req.ReqPayload = string(payload)
+
+	var span *Span
+	if spanExporter() != nil {
+		span = startChildSpan(ctx, rpcMethodName(&rpcReq.req), "client")
+		rpcReq.req.Context[RpcContextTraceParent] = span.TraceParent()
+	}
+	done := func(rsp *ResponsePacket) {
+		span.finishRpc(rsp)
+		rpc.rpcimp.finish(rpc.Session, rpcReq, rsp)
+	}
+	invoker := rpc.send(rpcReq)
+	if !rpcReq.req.IsOneWay && rpcReq.stream == nil { //a stream or a one way call is never sent twice
+		if opt.Retry != nil && opt.Retry.MaxAttempts > 1 {
+			invoker = rpc.retry(*opt.Retry, invoker)
 		}
+		if opt.HedgeDelay > 0 {
+			invoker = rpc.hedge(opt.HedgeDelay, opt.MaxHedges, invoker)
+		}
+	}
+	err = rpcClientMetrics(ctx, &rpcReq.req, done, chainClient(rpc.interceptors, invoker))
+	if rpcReq.req.IsOneWay || err != nil { //done is never called
+		span.Finish()
 	}
-	rpcReq.req.ReqPayload = string(sdp.buf)
+	return err
+}
+
+func (rpc *RPC) nextSequence() uint32 {
+	return atomic.AddUint32(&rpc.ReqSequence, 1) - 1
+}
+
+//send is the end of the client interceptor chain, it may be called more than once for a call
+func (rpc *RPC) send(rpcReq rpcRequest) RpcInvoker {
+	return func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket)) error {
+		if !req.IsOneWay {
+			pending := rpcReq
+			pending.req = *req
+			pending.done = done
+			pending.deadline = time.Now().Add(time.Duration(req.Timeout) * time.Millisecond)
+			rpc.rpcimp.pushRequest(pending)
+		}
+		if err := SendSdp(rpc.Session, Encode(*req)); err != nil {
+			rpc.rpcimp.popRequest(req.RequestId)
+			return err
+		}
+		return nil
+	}
+}
+
+//AddInterceptor appends ic to the interceptors of later calls, the first added is the outermost
+func (rpc *RPC) AddInterceptor(ic ClientInterceptor) {
+	rpc.interceptors = append(rpc.interceptors, ic)
+}
 
-	rpc.imp.(*RPCImp).pushRequest(rpcReq)
-	rpc.ReqSequence++
+//SetFrameOption makes the client negotiate compression and crc with the service on connect,
+//the service must understand the v1 frame header
+func (rpc *RPC) SetFrameOption(opt FrameOption) {
+	rpc.rpcimp.frame = &opt
+}
 
-	return rpc.Send(PackSdpProtocol(Encode(rpcReq.req)))
+//SetCodec selects the codec registered by name for params and results of later calls
+func (rpc *RPC) SetCodec(name string) error {
+	c := GetCodec(name)
+	if c == nil {
+		return fmt.Errorf("unknown rpc codec:%s", name)
+	}
+	rpc.codec = c
+	return nil
 }
 
-func newRPC(name, servicename, address string) (*RPC, error) {
-	rpcimp := &RPCImp{}
-	ct, err := newConnect(name, address, 100, rpcimp)
+//the client connects to address, or to the addresses of servicename from r if r is not nil
+func newRPC(name, servicename, address string, r Resolver) (*RPC, error) {
+	rpcimp := &RPCImp{requests: make(map[uint32]rpcRequest), pushes: make(map[string]interface{})}
+	var ct *Connect
+	var err error
+	if r != nil {
+		ct, err = newResolverConnect(name, servicename, r, 100, rpcimp)
+	} else {
+		ct, err = newConnect(name, address, 100, rpcimp)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &RPC{ct, rpcimp, servicename, 1}, nil
+	return &RPC{Connect: ct, rpcimp: rpcimp, ServiceName: servicename, ReqSequence: 1, codec: SdpCodec{},
+		options: make(map[string]RpcMethodOption), defaultOpt: DefaultRpcMethodOption}, nil
 }
 
+//requests and timers are used by the callers of the rpc and the loop thread,
+//callbacks are called on the loop thread without holding the lock
 type RPCImp struct {
+	sync.Mutex
 	requests map[uint32]rpcRequest
+	timers   []rpcTimer
+	pushes   map[string]interface{}
+	frame    *FrameOption
 }
 
 func (rpc *RPCImp) pushRequest(req rpcRequest) bool {
+	rpc.Lock()
+	defer rpc.Unlock()
 	rpc.requests[req.req.RequestId] = req
 	return true
 }
 
+func (rpc *RPCImp) popRequest(id uint32) (rpcRequest, bool) {
+	rpc.Lock()
+	defer rpc.Unlock()
+	v, ok := rpc.requests[id]
+	if ok {
+		delete(rpc.requests, id)
+	}
+	return v, ok
+}
+
 func (rpc *RPCImp) Init() bool {
-	rpc.requests = make(map[uint32]rpcRequest)
 	return true
 }
 func (rpc *RPCImp) Loop() {
-	now := time.Now().Unix()
+	now := time.Now()
+	var expired []rpcRequest
+	rpc.Lock()
 	for k, v := range rpc.requests {
-		if v.timeout < now {
-			if v.exception != nil {
-				v.exception(SDPASYNCCALLTIMEOUT)
-			}
+		if v.deadline.Before(now) {
 			delete(rpc.requests, k)
+			expired = append(expired, v)
 		}
 	}
+	rpc.Unlock()
+	for _, v := range expired {
+		v.done(&ResponsePacket{MfwRet: SDPASYNCCALLTIMEOUT, RequestId: v.req.RequestId, ErrMsg: ErrRpcTimeOut.Error()})
+	}
+	rpc.runTimers(now)
 }
 func (rpc *RPCImp) Destroy() {
 
@@ -177,154 +377,390 @@ func (rpc *RPCImp) RegisterCMessage(ct *Connect) {
 
 func (rpc *RPCImp) HandleCallBack(s *Session, i interface{}) {
 	rsp := i.(*ResponsePacket)
+	if rsp.PushName != "" {
+		rpc.handlePush(s, rsp)
+		return
+	}
+
+	rpc.Lock()
 	v, ok := rpc.requests[rsp.RequestId]
+	if ok && (rsp.StreamSeq == 0 || v.stream == nil) {
+		delete(rpc.requests, rsp.RequestId)
+	}
+	rpc.Unlock()
 	if !ok {
 		rpc.HandleError(s, ErrRpcRspTimeOut)
 		return
 	}
 
-	delete(rpc.requests, rsp.RequestId)
+	if rsp.StreamSeq > 0 && v.stream != nil {
+		rpc.handleStream(s, v, rsp)
+		return
+	}
+	v.done(rsp)
+}
 
+//finish calls the callback or the exception function of a call with its final response
+func (rpc *RPCImp) finish(s *Session, v rpcRequest, rsp *ResponsePacket) {
 	if rsp.MfwRet != 0 {
 		if v.exception != nil {
-			v.exception(rsp.MfwRet)
+			v.exception(NewRpcError(rsp.MfwRet, rsp.ErrMsg))
 		}
-	} else {
-		sdp := Sdp{[]byte(rsp.RspPayload), 0}
-
-		if v.callback != nil {
-			funcT := reflect.TypeOf(v.callback)
-			funcVals := make([]reflect.Value, funcT.NumIn())
-			for i := 0; i < funcT.NumIn(); i++ {
-				t := funcT.In(i)
-				val := newValByType(t)
-				e := sdp.unpack(val, true)
-				if e != nil {
-					if v.exception != nil {
-						v.exception(SDPRPCFUNCPARAMSEERR)
-					}
-					rpc.HandleError(s, e)
-					return
-				}
-				funcVals[i] = val
+	} else if v.stream != nil {
+		v.exception(nil)
+	} else if v.callback != nil {
+		e := callWithPayload(v.codec, v.callback, rsp.RspPayload)
+		if e != nil {
+			if v.exception != nil {
+				v.exception(NewRpcError(SDPRPCFUNCPARAMSEERR, e.Error()))
 			}
-			funcV := reflect.ValueOf(v.callback)
-			funcV.Call(funcVals)
+			rpc.HandleError(s, e)
 		}
 	}
-
 }
 
 func (rpc *RPCImp) Unmarshal(sess *Session, data []byte) (lenParsed int, msgID uint32, msg interface{}, err error) {
-	if len(data) < 4 {
-		return 0, 0, nil, nil
-	}
-	msgLen := SdpLen(data)
-	if len(data) < int(msgLen) {
-		return 0, 0, nil, nil
+	msgLen, payload, e := unpackSessionFrame(sess, data, nil)
+	if e != nil || payload == nil {
+		return msgLen, 0, nil, e
 	}
 	rsp := &ResponsePacket{}
-	e := Decode(rsp, data[4:msgLen])
+	e = Decode(rsp, payload)
 	if e != nil {
-		return int(msgLen), 0, nil, e
+		return msgLen, 0, nil, e
 	}
-	return int(msgLen), 0, rsp, nil
+	return msgLen, 0, rsp, nil
 }
 func (rpc *RPCImp) Connected(sess *Session) {
-
+	if rpc.frame != nil {
+		if err := sendFrameHello(sess, *rpc.frame); err != nil {
+			rpc.HandleError(sess, err)
+		}
+	}
 }
-func (rpc *RPCImp) DisConnected(sess *Session) {
 
+//DisConnected fails all pending requests with SDPCONNCLOSEDERR, the responses will never arrive
+func (rpc *RPCImp) DisConnected(sess *Session) {
+	rpc.Lock()
+	pending := make([]rpcRequest, 0, len(rpc.requests))
+	for k, v := range rpc.requests {
+		pending = append(pending, v)
+		delete(rpc.requests, k)
+	}
+	rpc.Unlock()
+	for _, v := range pending {
+		v.done(&ResponsePacket{MfwRet: SDPCONNCLOSEDERR, RequestId: v.req.RequestId, ErrMsg: ErrRpcConnClosed.Error()})
+	}
 }
 func (rpc *RPCImp) HandleError(sess *Session, err error) {
-	fmt.Println(err.Error())
+	logError(nil, sess, err)
+}
+
+//RpcConcurrency decides where the rpc functions of a service run
+type RpcConcurrency int
+
+const (
+	RpcInline         RpcConcurrency = iota //on the loop thread of the service
+	RpcSessionOrdered                       //on a goroutine per session, calls of a session run in order
+	RpcParallel                             //on a goroutine per call
+)
+
+type RpcServerOption struct {
+	Mode RpcConcurrency
+	//calls running or waiting more than this are rejected with SDPSERVEROVERLOAD, 0 means no limit
+	MaxInFlight int
+	//calls waiting longer than this before running are rejected with SDPSERVERQUEUETIMEOUT, 0 means no limit;
+	//calls which waited longer than RequestPacket.Timeout are always rejected
+	QueueTimeout time.Duration
+	//params and results of the rpc functions are checked with this codec on registration, sdp if empty
+	Codec string
+	//calls go through these in order before reaching the rpc function
+	Interceptors []ServerInterceptor
+	//errors of the calls, stnet.Logger() if nil
+	Log *stlog.Logger
+}
+
+//calls queued for a session in RpcSessionOrdered mode
+const rpcSessionQueueLen = 1024
+
+type rpcCall struct {
+	req  *RequestPacket
+	recv time.Time
 }
 
 type RPCServerImp struct {
-	rpcFuncs interface{}
+	services map[string]*rpcService
+	first    *rpcService
+	opt      RpcServerOption
+	inflight int32
+	sessions map[uint64]chan *rpcCall
+	streams  rpcStreams
+}
+
+func newRPCServerImp(name string, rpcFuncStruct interface{}, opt RpcServerOption) (*RPCServerImp, error) {
+	rpc := &RPCServerImp{services: make(map[string]*rpcService), opt: opt}
+	//the reflection service is sdp whatever the codec of the others, its types may not fit them
+	reflection, err := newRpcService(RpcReflectionService, &rpcReflection{rpc}, SdpCodec{})
+	if err != nil {
+		return nil, err
+	}
+	rpc.services[RpcReflectionService] = reflection
+	if err := rpc.addService(name, rpcFuncStruct); err != nil {
+		return nil, err
+	}
+	return rpc, nil
+}
+
+func (rpc *RPCServerImp) addService(name string, rpcFuncStruct interface{}) error {
+	if _, ok := rpc.services[name]; ok {
+		return fmt.Errorf("rpc service %s exists", name)
+	}
+	codec := Codec(SdpCodec{})
+	if rpc.opt.Codec != "" {
+		if codec = GetCodec(rpc.opt.Codec); codec == nil {
+			return fmt.Errorf("unknown rpc codec:%s", rpc.opt.Codec)
+		}
+	}
+	svc, err := newRpcService(name, rpcFuncStruct, codec)
+	if err != nil {
+		return err
+	}
+	rpc.services[name] = svc
+	if rpc.first == nil && name != RpcReflectionService {
+		rpc.first = svc
+	}
+	return nil
+}
+
+//findService routes a request by RequestPacket.ServiceName, a listener with one service
+//serves requests of any name as it did before services were routed
+func (rpc *RPCServerImp) findService(name string) *rpcService {
+	if svc, ok := rpc.services[name]; ok {
+		return svc
+	}
+	if len(rpc.services) == 2 { //the reflection service and the first one
+		return rpc.first
+	}
+	return nil
 }
 
 func (rpc *RPCServerImp) Init() bool {
+	rpc.sessions = make(map[uint64]chan *rpcCall)
 	return true
 }
 func (rpc *RPCServerImp) Loop() {
 }
 func (rpc *RPCServerImp) Destroy() {
-
+	for k, q := range rpc.sessions {
+		close(q)
+		delete(rpc.sessions, k)
+	}
 }
 func (rpc *RPCServerImp) RegisterSMessage(s *Service) {
 	s.RegisterMessage(0, rpc.HandleRpcRequest)
 }
 func (rpc *RPCServerImp) HandleRpcRequest(s *Session, i interface{}) {
-	req := i.(*RequestPacket)
+	call, ok := i.(*rpcCall)
+	if !ok {
+		call = &rpcCall{i.(*RequestPacket), time.Now()}
+	}
+	if call.req.FuncName == rpcStreamAckFunc {
+		if e := rpc.streams.ack(s, call.req); e != nil {
+			rpc.HandleError(s, e)
+		}
+		return
+	}
+
+	inflight := atomic.AddInt32(&rpc.inflight, 1)
+	if rpc.opt.MaxInFlight > 0 && int(inflight) > rpc.opt.MaxInFlight {
+		atomic.AddInt32(&rpc.inflight, -1)
+		rpc.SendResponse(s, call.req, SDPSERVEROVERLOAD, "")
+		rpc.HandleError(s, fmt.Errorf("rpc server overload:%s", call.req.FuncName))
+		return
+	}
+
+	switch rpc.opt.Mode {
+	case RpcSessionOrdered:
+		q, ok := rpc.sessions[s.GetID()]
+		if !ok {
+			q = make(chan *rpcCall, rpcSessionQueueLen)
+			rpc.sessions[s.GetID()] = q
+			go func() {
+				for c := range q {
+					rpc.invoke(s, c)
+				}
+			}()
+		}
+		select {
+		case q <- call:
+		default:
+			atomic.AddInt32(&rpc.inflight, -1)
+			rpc.SendResponse(s, call.req, SDPSERVEROVERLOAD, "")
+			rpc.HandleError(s, fmt.Errorf("rpc server overload:%s", call.req.FuncName))
+		}
+	case RpcParallel:
+		go rpc.invoke(s, call)
+	default:
+		//a stream waits for acks handled on the loop thread
+		if rpc.isStreamCall(call.req) {
+			go rpc.invoke(s, call)
+		} else {
+			rpc.invoke(s, call)
+		}
+	}
+}
+
+func (rpc *RPCServerImp) isStreamCall(req *RequestPacket) bool {
+	if svc := rpc.findService(req.ServiceName); svc != nil {
+		if m, ok := svc.methods[req.FuncName]; ok {
+			return m.hasStream
+		}
+	}
+	return false
+}
+
+func (rpc *RPCServerImp) invoke(s *Session, call *rpcCall) {
+	defer atomic.AddInt32(&rpc.inflight, -1)
+
+	req := call.req
+	defer func() {
+		if r := recover(); r != nil {
+			rpc.SendError(s, req, NewRpcError(SDPSERVERUNKNOWNERR, fmt.Sprintf("panic: %v", r)))
+			rpc.HandleError(s, fmt.Errorf("rpc function %s panic: %v\n%s", req.FuncName, r, debug.Stack()))
+		}
+	}()
+	waited := time.Since(call.recv)
+	if (rpc.opt.QueueTimeout > 0 && waited > rpc.opt.QueueTimeout) ||
+		(req.Timeout > 0 && waited > time.Duration(req.Timeout)*time.Millisecond) {
+		rpc.SendResponse(s, req, SDPSERVERQUEUETIMEOUT, "")
+		rpc.HandleError(s, fmt.Errorf("rpc queue timeout:%s", req.FuncName))
+		return
+	}
 
-	m, ok := reflect.TypeOf(rpc.rpcFuncs).MethodByName(req.FuncName)
+	if req.Context == nil {
+		req.Context = make(map[string]string)
+	}
+	ctx := context.Background()
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, call.recv.Add(time.Duration(req.Timeout)*time.Millisecond))
+		defer cancel()
+	}
+	ctx = newRpcContext(ctx, s, req)
+
+	var span *Span
+	if spanExporter() != nil {
+		traceID, parentID, _ := parseTraceParent(req.Context[RpcContextTraceParent])
+		span = newSpan(traceID, parentID, rpcMethodName(req), "server")
+		ctx = contextWithSpan(ctx, span)
+	}
+	rsp := rpcServerMetrics(ctx, req, chainServer(rpc.opt.Interceptors, rpc.handle))
+	span.finishRpc(rsp)
+	if rsp != nil {
+		rpc.sendPacket(s, req, rsp)
+	}
+}
+
+//handle is the end of the server interceptor chain, it calls the rpc function
+func (rpc *RPCServerImp) handle(ctx context.Context, req *RequestPacket) *ResponsePacket {
+	s := RpcSession(ctx)
+	svc := rpc.findService(req.ServiceName)
+	if svc == nil {
+		rpc.HandleError(s, fmt.Errorf("no rpc service:%s", req.ServiceName))
+		return &ResponsePacket{MfwRet: SDPSERVERNOSERVICEERR}
+	}
+	m, ok := svc.methods[req.FuncName]
 	if !ok {
-		rpc.SendResponse(s, req, SDPSERVERNOFUNCERR, "")
 		rpc.HandleError(s, fmt.Errorf("no rpc funciton:%s", req.FuncName))
-		return
+		return &ResponsePacket{MfwRet: SDPSERVERNOFUNCERR}
 	}
 
-	sdp := Sdp{[]byte(req.ReqPayload), 0}
+	codec, e := codecFromContext(req.Context)
+	if e != nil {
+		rpc.HandleError(s, e)
+		return &ResponsePacket{MfwRet: SDPRPCFUNCPARAMSEERR}
+	}
 
-	funcT := m.Type
-	funcVals := make([]reflect.Value, funcT.NumIn())
-	funcVals[0] = reflect.ValueOf(rpc.rpcFuncs)
-	for i := 1; i < funcT.NumIn(); i++ {
-		t := funcT.In(i)
-		val := newValByType(t)
-		e := sdp.unpack(val, true)
-		if e != nil {
-			rpc.SendResponse(s, req, SDPRPCFUNCPARAMSEERR, "")
-			rpc.HandleError(s, e)
-			return
+	funcVals := []reflect.Value{svc.rcvr}
+	if m.hasCtx {
+		funcVals = append(funcVals, reflect.ValueOf(ctx))
+	}
+	params, e := codec.Unmarshal([]byte(req.ReqPayload), m.params)
+	if e != nil {
+		rpc.HandleError(s, e)
+		return &ResponsePacket{MfwRet: SDPRPCFUNCPARAMSEERR}
+	}
+	funcVals = append(funcVals, params...)
+	if m.hasStream {
+		st := rpc.streams.open(s, req, codec)
+		defer rpc.streams.close(st)
+		funcVals = append(funcVals, reflect.ValueOf(st))
+	}
+	returns := m.fn.Call(funcVals)
+
+	//a returned error is sent as MfwRet and ErrMsg, but not packed with other results
+	if m.hasErr {
+		if err, _ := returns[m.nResults].Interface().(error); err != nil {
+			rpcErr, ok := err.(*RpcError)
+			if !ok || rpcErr.Code == 0 {
+				rpcErr = NewRpcError(SDPRPCFUNCERR, err.Error())
+			}
+			return &ResponsePacket{MfwRet: rpcErr.Code, ErrMsg: rpcErr.Msg}
 		}
-		funcVals[i] = val
+		returns = returns[:m.nResults]
 	}
-	funcV := m.Func
-	returns := funcV.Call(funcVals)
 
-	sdpSend := Sdp{}
+	results := make([]interface{}, len(returns))
 	for i, v := range returns {
-		e := sdpSend.pack(uint32(i+1), v.Interface(), true, true)
-		if e != nil {
-			rpc.SendResponse(s, req, SDPRPCFUNCPARAMSEERR, "")
-			rpc.HandleError(s, e)
-			return
-		}
+		results[i] = v.Interface()
 	}
+	payload, e := codec.Marshal(results)
+	if e != nil {
+		rpc.HandleError(s, e)
+		return &ResponsePacket{MfwRet: SDPRPCFUNCPARAMSEERR}
+	}
+	return &ResponsePacket{RspPayload: string(payload)}
+}
 
-	rpc.SendResponse(s, req, 0, string(sdpSend.buf))
+func (rpc *RPCServerImp) sendPacket(s *Session, req *RequestPacket, rsp *ResponsePacket) error {
+	if req.IsOneWay {
+		return nil
+	}
+	rsp.RequestId = req.RequestId
+	if rsp.Context == nil {
+		rsp.Context = req.Context
+	}
+	return SendSdp(s, Encode(*rsp))
 }
 
 func (rpc *RPCServerImp) SendResponse(s *Session, req *RequestPacket, ret int32, msg string) error {
-	rsp := ResponsePacket{}
-	rsp.MfwRet = ret
-	rsp.RequestId = req.RequestId
-	rsp.Context = req.Context
-	rsp.RspPayload = msg
-	return s.Send(PackSdpProtocol(Encode(rsp)))
+	return rpc.sendPacket(s, req, &ResponsePacket{MfwRet: ret, RspPayload: msg})
+}
+
+func (rpc *RPCServerImp) SendError(s *Session, req *RequestPacket, err *RpcError) error {
+	return rpc.sendPacket(s, req, &ResponsePacket{MfwRet: err.Code, ErrMsg: err.Msg})
 }
 
 func (rpc *RPCServerImp) Unmarshal(sess *Session, data []byte) (lenParsed int, msgID uint32, msg interface{}, err error) {
-	if len(data) < 4 {
-		return 0, 0, nil, nil
-	}
-	msgLen := SdpLen(data)
-	if len(data) < int(msgLen) {
-		return 0, 0, nil, nil
+	msgLen, payload, e := unpackSessionFrame(sess, data, &DefaultFrameOption)
+	if e != nil || payload == nil {
+		return msgLen, 0, nil, e
 	}
 	req := &RequestPacket{}
-	e := Decode(req, data[4:msgLen])
+	e = Decode(req, payload)
 	if e != nil {
-		return int(msgLen), 0, nil, e
+		return msgLen, 0, nil, e
 	}
-	return int(msgLen), 0, req, nil
+	return msgLen, 0, &rpcCall{req, time.Now()}, nil
 }
 func (rpc *RPCServerImp) SessionOpen(sess *Session) {
 }
 func (rpc *RPCServerImp) SessionClose(sess *Session) {
+	if q, ok := rpc.sessions[sess.GetID()]; ok {
+		close(q)
+		delete(rpc.sessions, sess.GetID())
+	}
 }
 func (rpc *RPCServerImp) HandleError(sess *Session, err error) {
-	fmt.Println(err.Error())
+	logError(rpc.opt.Log, sess, err)
 }
@@ -0,0 +1,50 @@
+package stnet
+
+import (
+	"context"
+	"reflect"
+)
+
+//an rpc function may take a context.Context as the first param,
+//it carries the RequestPacket.Context, the deadline from RequestPacket.Timeout and the peer session
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+type rpcContextKey struct{}
+
+type rpcCallInfo struct {
+	sess *Session
+	req  *RequestPacket
+}
+
+func newRpcContext(parent context.Context, sess *Session, req *RequestPacket) context.Context {
+	return context.WithValue(parent, rpcContextKey{}, &rpcCallInfo{sess, req})
+}
+
+func rpcInfo(ctx context.Context) *rpcCallInfo {
+	info, _ := ctx.Value(rpcContextKey{}).(*rpcCallInfo)
+	return info
+}
+
+//RpcSession returns the session of the caller
+func RpcSession(ctx context.Context) *Session {
+	if info := rpcInfo(ctx); info != nil {
+		return info.sess
+	}
+	return nil
+}
+
+//RpcContextMap returns RequestPacket.Context of the call, changes of it are sent back in ResponsePacket.Context
+func RpcContextMap(ctx context.Context) map[string]string {
+	if info := rpcInfo(ctx); info != nil {
+		return info.req.Context
+	}
+	return nil
+}
+
+//RpcRequest returns the request of the call
+func RpcRequest(ctx context.Context) *RequestPacket {
+	if info := rpcInfo(ctx); info != nil {
+		return info.req
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package stnet
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/sstask/golib/stlog"
+)
+
+//RpcInvoker sends a call, done is called with its response on the loop thread of the client;
+//done is never called for one way calls
+type RpcInvoker func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket)) error
+
+//ClientInterceptor may change req before calling next, wrap done to see the response,
+//call next again from done to retry, or call done itself without next to short-circuit
+type ClientInterceptor func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket), next RpcInvoker) error
+
+//RpcHandler handles a call and returns its response, ctx is the one given to the rpc function
+type RpcHandler func(ctx context.Context, req *RequestPacket) *ResponsePacket
+
+//ServerInterceptor may change req before calling next, change the response returned by next,
+//or return its own response without next to short-circuit
+type ServerInterceptor func(ctx context.Context, req *RequestPacket, next RpcHandler) *ResponsePacket
+
+//keys of RequestPacket.Context used by the built-in interceptors
+const (
+	RpcContextRequestID = "request-id"
+	RpcContextAuth      = "authorization"
+)
+
+func chainClient(ics []ClientInterceptor, invoker RpcInvoker) RpcInvoker {
+	for i := len(ics) - 1; i >= 0; i-- {
+		ic, next := ics[i], invoker
+		invoker = func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket)) error {
+			return ic(ctx, req, done, next)
+		}
+	}
+	return invoker
+}
+
+func chainServer(ics []ServerInterceptor, handler RpcHandler) RpcHandler {
+	for i := len(ics) - 1; i >= 0; i-- {
+		ic, next := ics[i], handler
+		handler = func(ctx context.Context, req *RequestPacket) *ResponsePacket {
+			return ic(ctx, req, next)
+		}
+	}
+	return handler
+}
+
+func rpcMethodName(req *RequestPacket) string {
+	return req.ServiceName + "." + req.FuncName
+}
+
+/****** logging ******/
+
+//RpcLogClientInterceptor logs each call with its result and latency, failed calls as warnings
+func RpcLogClientInterceptor(log *stlog.Logger) ClientInterceptor {
+	return func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket), next RpcInvoker) error {
+		start := time.Now()
+		err := next(ctx, req, func(rsp *ResponsePacket) {
+			logRpc(log, "rpc call", req, rsp, time.Since(start))
+			done(rsp)
+		})
+		if err != nil {
+			log.Warn("rpc call %s id=%d send error:%s", rpcMethodName(req), req.RequestId, err.Error())
+		}
+		return err
+	}
+}
+
+//RpcLogServerInterceptor logs each handled call with its result and latency, failed calls as warnings
+func RpcLogServerInterceptor(log *stlog.Logger) ServerInterceptor {
+	return func(ctx context.Context, req *RequestPacket, next RpcHandler) *ResponsePacket {
+		start := time.Now()
+		rsp := next(ctx, req)
+		logRpc(log, "rpc serve", req, rsp, time.Since(start))
+		return rsp
+	}
+}
+
+func logRpc(log *stlog.Logger, what string, req *RequestPacket, rsp *ResponsePacket, cost time.Duration) {
+	reqID := req.Context[RpcContextRequestID]
+	if rsp == nil || rsp.MfwRet == 0 {
+		log.Info("%s %s id=%d request-id=%s cost=%v", what, rpcMethodName(req), req.RequestId, reqID, cost)
+		return
+	}
+	log.Warn("%s %s id=%d request-id=%s ret=%d msg=%s cost=%v", what, rpcMethodName(req), req.RequestId, reqID, rsp.MfwRet, rsp.ErrMsg, cost)
+}
+
+/****** metrics ******/
+
+//RpcObserver is called with each call, its response and the time it took, nil for a call never answered
+type RpcObserver func(req *RequestPacket, rsp *ResponsePacket, cost time.Duration)
+
+//ClientInterceptor observes calls from before next to the done of the call
+func (ob RpcObserver) ClientInterceptor() ClientInterceptor {
+	return func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket), next RpcInvoker) error {
+		start := time.Now()
+		return next(ctx, req, func(rsp *ResponsePacket) {
+			ob(req, rsp, time.Since(start))
+			done(rsp)
+		})
+	}
+}
+
+//ServerInterceptor observes calls from before next to its return
+func (ob RpcObserver) ServerInterceptor() ServerInterceptor {
+	return func(ctx context.Context, req *RequestPacket, next RpcHandler) *ResponsePacket {
+		start := time.Now()
+		rsp := next(ctx, req)
+		ob(req, rsp, time.Since(start))
+		return rsp
+	}
+}
+
+type RpcMethodStats struct {
+	Calls  uint64
+	Errors uint64 //calls with a non zero MfwRet
+	Total  time.Duration
+	Max    time.Duration
+}
+
+//RpcStats counts calls and their latency by "ServiceName.FuncName"
+type RpcStats struct {
+	sync.Mutex
+	methods map[string]*RpcMethodStats
+}
+
+func NewRpcStats() *RpcStats {
+	return &RpcStats{methods: make(map[string]*RpcMethodStats)}
+}
+
+func (st *RpcStats) add(req *RequestPacket, rsp *ResponsePacket, cost time.Duration) {
+	st.Lock()
+	defer st.Unlock()
+	name := rpcMethodName(req)
+	m, ok := st.methods[name]
+	if !ok {
+		m = &RpcMethodStats{}
+		st.methods[name] = m
+	}
+	m.Calls++
+	if rsp != nil && rsp.MfwRet != 0 {
+		m.Errors++
+	}
+	m.Total += cost
+	if cost > m.Max {
+		m.Max = cost
+	}
+}
+
+//Snapshot returns a copy of the stats
+func (st *RpcStats) Snapshot() map[string]RpcMethodStats {
+	st.Lock()
+	defer st.Unlock()
+	ret := make(map[string]RpcMethodStats, len(st.methods))
+	for k, v := range st.methods {
+		ret[k] = *v
+	}
+	return ret
+}
+
+func (st *RpcStats) ClientInterceptor() ClientInterceptor {
+	return RpcObserver(st.add).ClientInterceptor()
+}
+
+func (st *RpcStats) ServerInterceptor() ServerInterceptor {
+	return RpcObserver(st.add).ServerInterceptor()
+}
+
+/****** request id ******/
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//RpcRequestID returns the request id of the call handled with ctx
+func RpcRequestID(ctx context.Context) string {
+	return RpcContextMap(ctx)[RpcContextRequestID]
+}
+
+//RpcRequestIDClientInterceptor sets the request id of a call, it is taken from ctx if the call is made
+//while handling another call, so the id follows a request through services
+func RpcRequestIDClientInterceptor() ClientInterceptor {
+	return func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket), next RpcInvoker) error {
+		if req.Context[RpcContextRequestID] == "" {
+			id := RpcRequestID(ctx)
+			if id == "" {
+				id = newRequestID()
+			}
+			req.Context[RpcContextRequestID] = id
+		}
+		return next(ctx, req, done)
+	}
+}
+
+//RpcRequestIDServerInterceptor gives calls without a request id a new one, it is sent back in ResponsePacket.Context
+func RpcRequestIDServerInterceptor() ServerInterceptor {
+	return func(ctx context.Context, req *RequestPacket, next RpcHandler) *ResponsePacket {
+		if req.Context[RpcContextRequestID] == "" {
+			req.Context[RpcContextRequestID] = newRequestID()
+		}
+		return next(ctx, req)
+	}
+}
+
+/****** auth ******/
+
+//RpcAuthClientInterceptor sends token with each call
+func RpcAuthClientInterceptor(token string) ClientInterceptor {
+	return func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket), next RpcInvoker) error {
+		req.Context[RpcContextAuth] = token
+		return next(ctx, req, done)
+	}
+}
+
+//RpcAuthServerInterceptor rejects calls whose token is not accepted by check with SDPSERVERAUTHERR,
+//the token is removed from the context before the call goes on
+func RpcAuthServerInterceptor(check func(token string) bool) ServerInterceptor {
+	return func(ctx context.Context, req *RequestPacket, next RpcHandler) *ResponsePacket {
+		token := req.Context[RpcContextAuth]
+		delete(req.Context, RpcContextAuth)
+		if !check(token) {
+			return &ResponsePacket{MfwRet: SDPSERVERAUTHERR, ErrMsg: "unauthorized"}
+		}
+		return next(ctx, req)
+	}
+}
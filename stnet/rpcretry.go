@@ -0,0 +1,195 @@
+package stnet
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//RpcMethodOption is the client side option of calls to a function
+type RpcMethodOption struct {
+	Timeout time.Duration //also sent as RequestPacket.Timeout, DefaultRpcMethodOption.Timeout if 0
+	//Retry is only for idempotent functions, a call may run on the server more than once
+	Retry *RpcRetryPolicy
+	//if no response arrives in HedgeDelay, one more request is sent, at most MaxHedges (1 if 0) times;
+	//the first success wins, it is only for idempotent functions too
+	HedgeDelay time.Duration
+	MaxHedges  int
+}
+
+var DefaultRpcMethodOption = RpcMethodOption{Timeout: 5 * time.Second}
+
+type RpcRetryPolicy struct {
+	MaxAttempts int           //including the first one
+	Backoff     time.Duration //wait before the first retry, doubled for each later one
+	MaxBackoff  time.Duration //0 means no limit
+	Codes       []int32       //MfwRet retried, DefaultRetryCodes if empty; a failed send is SDPPROXYCONNECTERR
+}
+
+var DefaultRetryCodes = []int32{SDPPROXYCONNECTERR, SDPCONNCLOSEDERR, SDPSERVEROVERLOAD, SDPSERVERQUEUETIMEOUT, SDPASYNCCALLTIMEOUT}
+
+func (p *RpcRetryPolicy) retryable(code int32) bool {
+	codes := p.Codes
+	if len(codes) == 0 {
+		codes = DefaultRetryCodes
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RpcRetryPolicy) backoff(attempt int) time.Duration {
+	if attempt > 30 {
+		attempt = 30
+	}
+	b := p.Backoff << uint(attempt-1)
+	if p.MaxBackoff > 0 && b > p.MaxBackoff {
+		b = p.MaxBackoff
+	}
+	return b
+}
+
+//SetMethodOption sets the option of later calls to funcName
+func (rpc *RPC) SetMethodOption(funcName string, opt RpcMethodOption) {
+	rpc.options[funcName] = opt
+}
+
+//SetDefaultMethodOption sets the option of later calls to functions without their own option
+func (rpc *RPC) SetDefaultMethodOption(opt RpcMethodOption) {
+	rpc.defaultOpt = opt
+}
+
+func (rpc *RPC) methodOption(funcName string) RpcMethodOption {
+	opt, ok := rpc.options[funcName]
+	if !ok {
+		opt = rpc.defaultOpt
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = rpc.defaultOpt.Timeout
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = DefaultRpcMethodOption.Timeout
+	}
+	return opt
+}
+
+func sendErrorPacket(req *RequestPacket, err error) *ResponsePacket {
+	return &ResponsePacket{MfwRet: SDPPROXYCONNECTERR, RequestId: req.RequestId, ErrMsg: err.Error()}
+}
+
+//retry sends the call again with a new RequestId after a backoff if it failed with a retryable code
+func (rpc *RPC) retry(policy RpcRetryPolicy, next RpcInvoker) RpcInvoker {
+	return func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket)) error {
+		attempt := 1
+		var onDone func(*ResponsePacket)
+		onDone = func(rsp *ResponsePacket) {
+			if attempt >= policy.MaxAttempts || !policy.retryable(rsp.MfwRet) {
+				done(rsp)
+				return
+			}
+			rpc.rpcimp.after(policy.backoff(attempt), func() {
+				attempt++
+				r := *req
+				r.RequestId = rpc.nextSequence()
+				if err := next(ctx, &r, onDone); err != nil {
+					onDone(sendErrorPacket(&r, err))
+				}
+			})
+		}
+		err := next(ctx, req, onDone)
+		if err != nil && policy.retryable(SDPPROXYCONNECTERR) {
+			onDone(sendErrorPacket(req, err))
+			return nil
+		}
+		return err
+	}
+}
+
+type hedgeState struct {
+	sync.Mutex
+	finished    bool
+	outstanding int
+}
+
+//hedge sends copies of the call with new RequestIds while no response arrives,
+//the first success or the last failure is the result
+func (rpc *RPC) hedge(delay time.Duration, max int, next RpcInvoker) RpcInvoker {
+	if max <= 0 {
+		max = 1
+	}
+	return func(ctx context.Context, req *RequestPacket, done func(*ResponsePacket)) error {
+		st := &hedgeState{outstanding: 1}
+		onDone := func(rsp *ResponsePacket) {
+			st.Lock()
+			st.outstanding--
+			deliver := !st.finished && (rsp.MfwRet == 0 || st.outstanding == 0)
+			if deliver {
+				st.finished = true
+			}
+			st.Unlock()
+			if deliver {
+				done(rsp)
+			}
+		}
+		if err := next(ctx, req, onDone); err != nil {
+			return err
+		}
+
+		var send func(n int)
+		send = func(n int) {
+			rpc.rpcimp.after(delay, func() {
+				st.Lock()
+				if st.finished {
+					st.Unlock()
+					return
+				}
+				st.outstanding++
+				st.Unlock()
+				r := *req
+				r.RequestId = rpc.nextSequence()
+				if err := next(ctx, &r, onDone); err != nil {
+					onDone(sendErrorPacket(&r, err))
+					return
+				}
+				if n < max {
+					send(n + 1)
+				}
+			})
+		}
+		send(1)
+		return nil
+	}
+}
+
+type rpcTimer struct {
+	at time.Time
+	fn func()
+}
+
+//after calls fn on the loop thread after d
+func (rpc *RPCImp) after(d time.Duration, fn func()) {
+	rpc.Lock()
+	defer rpc.Unlock()
+	rpc.timers = append(rpc.timers, rpcTimer{time.Now().Add(d), fn})
+}
+
+func (rpc *RPCImp) runTimers(now time.Time) {
+	rpc.Lock()
+	var due []rpcTimer
+	timers := rpc.timers[:0]
+	for _, t := range rpc.timers {
+		if t.at.After(now) {
+			timers = append(timers, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	rpc.timers = timers
+	rpc.Unlock()
+	for _, t := range due {
+		t.fn()
+	}
+}
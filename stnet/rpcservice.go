@@ -0,0 +1,184 @@
+package stnet
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//the built-in service listing the services of a rpc listener, call its List() []RpcServiceDesc
+const RpcReflectionService = "stnet.Reflection"
+
+type RpcMethodDesc struct {
+	Name    string
+	Params  []string
+	Results []string
+}
+
+type RpcServiceDesc struct {
+	Name    string
+	Methods []RpcMethodDesc
+}
+
+type rpcMethod struct {
+	fn        reflect.Value
+	hasCtx    bool
+	hasErr    bool
+	hasStream bool
+	params    []reflect.Type
+	nResults  int
+	desc      RpcMethodDesc
+}
+
+type rpcService struct {
+	name    string
+	rcvr    reflect.Value
+	methods map[string]*rpcMethod
+}
+
+//newRpcService checks all exported methods of rcvr, their params and results must be supported by codec
+func newRpcService(name string, rcvr interface{}, codec Codec) (*rpcService, error) {
+	if rcvr == nil {
+		return nil, fmt.Errorf("rpc service %s is nil", name)
+	}
+	t := reflect.TypeOf(rcvr)
+	svc := &rpcService{name, reflect.ValueOf(rcvr), make(map[string]*rpcMethod)}
+	checker, _ := codec.(TypeChecker)
+	var errs []string
+	for i := 0; i < t.NumMethod(); i++ {
+		m, err := newRpcMethod(t.Method(i), checker)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		svc.methods[t.Method(i).Name] = m
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("rpc service %s: %s", name, strings.Join(errs, "; "))
+	}
+	return svc, nil
+}
+
+func newRpcMethod(m reflect.Method, checker TypeChecker) (*rpcMethod, error) {
+	funcT := m.Type
+	rm := &rpcMethod{fn: m.Func, desc: RpcMethodDesc{Name: m.Name}}
+	first := 1
+	if funcT.NumIn() > 1 && funcT.In(1) == contextType {
+		rm.hasCtx = true
+		first = 2
+	}
+	last := funcT.NumIn()
+	if last > first && funcT.In(last-1) == streamType {
+		rm.hasStream = true
+		last--
+	}
+	for i := first; i < last; i++ {
+		t := funcT.In(i)
+		if checker != nil {
+			if err := checker.CheckType(t); err != nil {
+				return nil, fmt.Errorf("%s param %d %s: %s", m.Name, i-first, t.String(), err.Error())
+			}
+		}
+		rm.params = append(rm.params, t)
+		rm.desc.Params = append(rm.desc.Params, t.String())
+	}
+	rm.nResults = funcT.NumOut()
+	if n := funcT.NumOut(); n > 0 && funcT.Out(n-1) == errorType {
+		rm.hasErr = true
+		rm.nResults--
+	}
+	for i := 0; i < rm.nResults; i++ {
+		t := funcT.Out(i)
+		if checker != nil {
+			if err := checker.CheckType(t); err != nil {
+				return nil, fmt.Errorf("%s result %d %s: %s", m.Name, i, t.String(), err.Error())
+			}
+		}
+		rm.desc.Results = append(rm.desc.Results, t.String())
+	}
+	if rm.hasStream {
+		rm.desc.Params = append(rm.desc.Params, streamType.String())
+	}
+	if rm.hasErr {
+		rm.desc.Results = append(rm.desc.Results, "error")
+	}
+	return rm, nil
+}
+
+func (svc *rpcService) describe() RpcServiceDesc {
+	desc := RpcServiceDesc{Name: svc.name}
+	for _, m := range svc.methods {
+		desc.Methods = append(desc.Methods, m.desc)
+	}
+	sort.Slice(desc.Methods, func(i, j int) bool {
+		return desc.Methods[i].Name < desc.Methods[j].Name
+	})
+	return desc
+}
+
+type rpcReflection struct {
+	imp *RPCServerImp
+}
+
+func (r *rpcReflection) List() []RpcServiceDesc {
+	descs := make([]RpcServiceDesc, 0, len(r.imp.services))
+	for _, svc := range r.imp.services {
+		descs = append(descs, svc.describe())
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].Name < descs[j].Name
+	})
+	return descs
+}
+
+//sdpCheckType tells if Sdp can encode and decode values of t
+func sdpCheckType(t reflect.Type) error {
+	return checkSdpType(t, make(map[reflect.Type]bool))
+}
+
+func checkSdpType(t reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return nil
+	case reflect.Slice:
+		return checkSdpType(t.Elem(), seen)
+	case reflect.Map:
+		if err := checkSdpType(t.Key(), seen); err != nil {
+			return err
+		}
+		return checkSdpType(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				return fmt.Errorf("unexported field %s.%s", t.String(), f.Name)
+			}
+			if err := checkSdpType(f.Type, seen); err != nil {
+				return err
+			}
+			if def, ok := f.Tag.Lookup("default"); ok {
+				if err := checkSdpDefault(f.Type, def); err != nil {
+					return fmt.Errorf("default of %s.%s: %v", t.String(), f.Name, err)
+				}
+			}
+		}
+		return nil
+	}
+	return errInvalidType
+}
+
+//checkSdpDefault tells if def is a valid default of a field of type t, only basic kinds have defaults
+func checkSdpDefault(t reflect.Type, def string) error {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		return errInvalidType
+	}
+	return setDefaultVal(reflect.New(t).Elem(), def)
+}
@@ -0,0 +1,171 @@
+package stnet
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStreamClosed = errors.New("rpc stream closed")
+	ErrNoPushFunc   = errors.New("no push function")
+)
+
+//a stream sends at most RpcStreamWindow messages which are not acked by the client,
+//the client acks every RpcStreamWindow/2 messages it handled
+const RpcStreamWindow = 64
+
+//the FuncName of the requests acking stream messages
+const rpcStreamAckFunc = "stnet.StreamAck"
+
+var streamType = reflect.TypeOf((*RpcStream)(nil))
+
+//RpcStream is taken by a server streaming rpc function as the last param,
+//the stream ends when the function returns
+type RpcStream struct {
+	sess   *Session
+	req    *RequestPacket
+	codec  Codec
+	seq    uint32
+	credit chan struct{}
+	closed chan struct{}
+}
+
+type rpcStreamKey struct {
+	sess uint64
+	id   uint32
+}
+
+type rpcStreams struct {
+	sync.Mutex
+	streams map[rpcStreamKey]*RpcStream
+}
+
+func (ss *rpcStreams) open(sess *Session, req *RequestPacket, codec Codec) *RpcStream {
+	st := &RpcStream{sess, req, codec, 0, make(chan struct{}, RpcStreamWindow), make(chan struct{})}
+	for i := 0; i < RpcStreamWindow; i++ {
+		st.credit <- struct{}{}
+	}
+	ss.Lock()
+	defer ss.Unlock()
+	if ss.streams == nil {
+		ss.streams = make(map[rpcStreamKey]*RpcStream)
+	}
+	ss.streams[rpcStreamKey{sess.GetID(), req.RequestId}] = st
+	return st
+}
+
+func (ss *rpcStreams) close(st *RpcStream) {
+	ss.Lock()
+	defer ss.Unlock()
+	delete(ss.streams, rpcStreamKey{st.sess.GetID(), st.req.RequestId})
+	close(st.closed)
+}
+
+func (ss *rpcStreams) ack(sess *Session, req *RequestPacket) error {
+	vals, err := (SdpCodec{}).Unmarshal([]byte(req.ReqPayload), []reflect.Type{reflect.TypeOf(uint32(0))})
+	if err != nil {
+		return err
+	}
+	ss.Lock()
+	st, ok := ss.streams[rpcStreamKey{sess.GetID(), req.RequestId}]
+	ss.Unlock()
+	if !ok {
+		return nil
+	}
+	for n := vals[0].Uint(); n > 0; n-- {
+		select {
+		case st.credit <- struct{}{}:
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+//Send sends a message to the client, it blocks while the client has not acked RpcStreamWindow messages
+func (st *RpcStream) Send(vals ...interface{}) error {
+	for {
+		select {
+		case <-st.closed:
+			return ErrStreamClosed
+		case <-st.credit:
+			payload, err := st.codec.Marshal(vals)
+			if err != nil {
+				return err
+			}
+			st.seq++
+			rsp := ResponsePacket{RequestId: st.req.RequestId, RspPayload: string(payload), Context: st.req.Context, StreamSeq: st.seq}
+			return SendSdp(st.sess, Encode(rsp))
+		case <-time.After(100 * time.Millisecond):
+			if st.sess.IsClose() {
+				return ErrStreamClosed
+			}
+		}
+	}
+}
+
+//RpcPush sends a message to a rpc client, it is handled by the function registered by RPC.RegisterPush
+func RpcPush(sess *Session, name string, params ...interface{}) error {
+	payload, err := (SdpCodec{}).Marshal(params)
+	if err != nil {
+		return err
+	}
+	return SendSdp(sess, Encode(ResponsePacket{RspPayload: string(payload), PushName: name}))
+}
+
+//callWithPayload decodes the params of fn from payload and calls it
+func callWithPayload(codec Codec, fn interface{}, payload string) error {
+	funcT := reflect.TypeOf(fn)
+	types := make([]reflect.Type, funcT.NumIn())
+	for i := 0; i < funcT.NumIn(); i++ {
+		types[i] = funcT.In(i)
+	}
+	funcVals, e := codec.Unmarshal([]byte(payload), types)
+	if e != nil {
+		return e
+	}
+	reflect.ValueOf(fn).Call(funcVals)
+	return nil
+}
+
+func (rpc *RPCImp) handlePush(s *Session, rsp *ResponsePacket) {
+	rpc.Lock()
+	fn, ok := rpc.pushes[rsp.PushName]
+	rpc.Unlock()
+	if !ok {
+		rpc.HandleError(s, fmt.Errorf("%s:%s", ErrNoPushFunc.Error(), rsp.PushName))
+		return
+	}
+	codec, e := codecFromContext(rsp.Context)
+	if e == nil {
+		e = callWithPayload(codec, fn, rsp.RspPayload)
+	}
+	if e != nil {
+		rpc.HandleError(s, e)
+	}
+}
+
+func (rpc *RPCImp) handleStream(s *Session, v rpcRequest, rsp *ResponsePacket) {
+	if e := callWithPayload(v.codec, v.stream, rsp.RspPayload); e != nil {
+		rpc.HandleError(s, e)
+	}
+
+	v.deadline = time.Now().Add(time.Duration(v.req.Timeout) * time.Millisecond)
+	v.unacked++
+	if v.unacked >= RpcStreamWindow/2 {
+		payload, _ := (SdpCodec{}).Marshal([]interface{}{v.unacked})
+		ack := RequestPacket{RequestId: v.req.RequestId, ServiceName: v.req.ServiceName, FuncName: rpcStreamAckFunc, ReqPayload: string(payload)}
+		if e := SendSdp(s, Encode(ack)); e != nil {
+			rpc.HandleError(s, e)
+		}
+		v.unacked = 0
+	}
+	rpc.Lock()
+	if _, ok := rpc.requests[rsp.RequestId]; ok { //not failed by a disconnection meanwhile
+		rpc.requests[rsp.RequestId] = v
+	}
+	rpc.Unlock()
+}
@@ -2,9 +2,12 @@
 package stnet
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"unsafe"
 )
@@ -15,6 +18,7 @@ var (
 	errInvalidType  = errors.New("invalid type")
 	errStructEnd    = errors.New("struct end")
 	errNeedPtr      = errors.New("ptr is needed")
+	errNotCanonical = errors.New("not canonical")
 )
 
 type Sdp struct {
@@ -91,13 +95,23 @@ func (sdp *Sdp) packMap(tag uint32, x interface{}, packHead bool, require bool)
 	}
 	sdp.packHeader(tag, SdpPackDataType_Map)
 	sdp.packNumber(uint64(refVal.Len()))
+	//keys are sorted by their encoded form, so the same map is always encoded to the same bytes
 	keys := refVal.MapKeys()
+	entries := make([]sdpMapEntry, 0, len(keys))
 	for i := 0; i < len(keys); i++ {
-		err := sdp.pack(0, keys[i].Interface(), true, true)
+		keySdp := Sdp{}
+		err := keySdp.pack(0, keys[i].Interface(), true, true)
 		if err != nil {
 			return err
 		}
-		err = sdp.pack(0, refVal.MapIndex(keys[i]).Interface(), true, true)
+		entries = append(entries, sdpMapEntry{keySdp.buf, keys[i]})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	for _, e := range entries {
+		sdp.packData(e.key)
+		err := sdp.pack(0, refVal.MapIndex(e.val).Interface(), true, true)
 		if err != nil {
 			return err
 		}
@@ -105,13 +119,23 @@ func (sdp *Sdp) packMap(tag uint32, x interface{}, packHead bool, require bool)
 	return nil
 }
 
+type sdpMapEntry struct {
+	key []byte
+	val reflect.Value
+}
+
 func (sdp *Sdp) packStruct(tag uint32, x interface{}, packHead bool) error {
 	if reflect.TypeOf(x).Kind() != reflect.Struct {
 		return errInvalidType
 	}
 	refVal := reflect.ValueOf(x)
 	sdp.packHeader(tag, SdpPackDataType_StructBegin)
+	var unknown []byte
 	for i := 0; i < refVal.NumField(); i++ {
+		if refVal.Type().Field(i).Name == SdpUnknownField {
+			unknown, _ = refVal.Field(i).Interface().([]byte)
+			continue
+		}
 		iTg := i
 		tg := refVal.Type().Field(i).Tag.Get("tag")
 		if tg != "" {
@@ -125,111 +149,120 @@ func (sdp *Sdp) packStruct(tag uint32, x interface{}, packHead bool) error {
 		if req == "true" {
 			require = true
 		}
+		//a zero value must be sent for a field with default, or the receiver would apply the default
+		if _, ok := refVal.Type().Field(i).Tag.Lookup("default"); ok {
+			require = true
+		}
 		fld := refVal.Field(i)
 		err := sdp.pack(uint32(iTg), fld.Interface(), true, require)
 		if err != nil {
 			return err
 		}
 	}
+	sdp.packData(unknown)
 	sdp.packHeader(0, SdpPackDataType_StructEnd)
 	return nil
 }
 
-func (sdp *Sdp) pack(tag uint32, x interface{}, packHead bool, require bool) error {
-	typ := SdpPackDataType_Integer_Positive
-	var val uint64
+var sdpBytesType = reflect.TypeOf([]byte(nil))
 
-	switch reflect.TypeOf(x).Kind() {
-	case reflect.Bool:
-		{
-			v := x.(bool)
-			if v {
-				val = 1
+//a []byte field with this name captures the fields unknown to the struct when decoding,
+//and they are sent again when encoding, so proxies do not strip fields of newer peers
+const SdpUnknownField = "XXX_unknown"
+
+//sdpFieldIndex finds the struct field by its tag, the index of the field is used as tag if it has no tag
+func sdpFieldIndex(t reflect.Type, tag uint32) int {
+	for i := 0; i < t.NumField(); i++ {
+		tg := t.Field(i).Tag.Get("tag")
+		if tg != "" {
+			iTg, er := strconv.Atoi(tg)
+			if er == nil && iTg == int(tag) {
+				return i
 			}
 		}
-	case reflect.Int:
-		{
-			v := x.(int)
-			if v < 0 {
-				typ = SdpPackDataType_Integer_Negative
-				v = -v
-			}
-			val = uint64(v)
+	}
+
+	i := int(tag)
+	if i < t.NumField() && t.Field(i).Tag.Get("tag") == "" && t.Field(i).Name != SdpUnknownField {
+		return i
+	}
+	return -1
+}
+
+func setDefaultVal(x reflect.Value, def string) error {
+	if !x.CanSet() {
+		return nil
+	}
+	switch x.Kind() {
+	case reflect.Bool:
+		v, err := strconv.ParseBool(def)
+		if err != nil {
+			return err
 		}
-	case reflect.Int8:
-		{
-			v := x.(int8)
-			if v < 0 {
-				typ = SdpPackDataType_Integer_Negative
-				v = -v
-			}
-			val = uint64(v)
+		x.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(def, 0, x.Type().Bits())
+		if err != nil {
+			return err
 		}
-	case reflect.Int16:
-		{
-			v := x.(int16)
-			if v < 0 {
-				typ = SdpPackDataType_Integer_Negative
-				v = -v
-			}
-			val = uint64(v)
+		x.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(def, 0, x.Type().Bits())
+		if err != nil {
+			return err
 		}
-	case reflect.Int32:
+		x.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(def, x.Type().Bits())
+		if err != nil {
+			return err
+		}
+		x.SetFloat(v)
+	case reflect.String:
+		x.SetString(def)
+	}
+	//other kinds have no default, checkSdpType rejects the tag on them
+	return nil
+}
+
+func (sdp *Sdp) pack(tag uint32, x interface{}, packHead bool, require bool) error {
+	typ := SdpPackDataType_Integer_Positive
+	var val uint64
+
+	//through reflect, so named types like "type ID int" are packed as their kind
+	rv := reflect.ValueOf(x)
+	switch rv.Kind() {
+	case reflect.Bool:
 		{
-			v := x.(int32)
-			if v < 0 {
-				typ = SdpPackDataType_Integer_Negative
-				v = -v
+			if rv.Bool() {
+				val = 1
 			}
-			val = uint64(v)
 		}
-	case reflect.Int64:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		{
-			v := x.(int64)
+			v := rv.Int()
 			if v < 0 {
 				typ = SdpPackDataType_Integer_Negative
-				v = -v
+				val = uint64(-v)
+			} else {
+				val = uint64(v)
 			}
-			val = uint64(v)
 		}
-	case reflect.Uint:
-		{
-			v := x.(uint)
-			val = uint64(v)
-		}
-	case reflect.Uint8:
-		{
-			v := x.(uint8)
-			val = uint64(v)
-		}
-	case reflect.Uint16:
-		{
-			v := x.(uint16)
-			val = uint64(v)
-		}
-	case reflect.Uint32:
-		{
-			v := x.(uint32)
-			val = uint64(v)
-		}
-	case reflect.Uint64:
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		{
-			v := x.(uint64)
-			val = uint64(v)
+			val = rv.Uint()
 		}
 	case reflect.Float32:
 		{
-			v := x.(float32)
-			val = uint64(*(*uint32)(unsafe.Pointer(&v)))
+			val = uint64(math.Float32bits(float32(rv.Float())))
 		}
 	case reflect.Float64:
 		{
-			v := x.(float64)
-			val = uint64(*(*uint64)(unsafe.Pointer(&v)))
+			val = math.Float64bits(rv.Float())
 		}
 	case reflect.String:
 		{
-			v := x.(string)
+			v := rv.String()
 			if len(v) == 0 && !require {
 				return nil
 			}
@@ -454,27 +487,8 @@ func (sdp *Sdp) unpack(x reflect.Value, first bool) error {
 
 	var valField reflect.Value
 	if x.Type().Kind() == reflect.Struct {
-		isTag := false
-		for i := 0; i < x.NumField(); i++ {
-			tg := x.Type().Field(i).Tag.Get("tag")
-			if tg != "" {
-				iTg, er := strconv.Atoi(tg)
-				if er == nil && iTg == int(tag) {
-					valField = x.Field(i)
-					isTag = true
-					break
-				}
-			}
-		}
-
-		if !isTag {
-			t := int(tag)
-			if t < x.NumField() {
-				tg := x.Type().Field(t).Tag.Get("tag")
-				if tg == "" {
-					valField = x.Field(t)
-				}
-			}
+		if i := sdpFieldIndex(x.Type(), tag); i >= 0 {
+			valField = x.Field(i)
 		}
 	}
 
@@ -669,7 +683,17 @@ func (sdp *Sdp) unpack(x reflect.Value, first bool) error {
 				sdp.skipToStructEnd()
 				return nil
 			}
+			stType := stVal.Type()
+			unknown := stVal.FieldByName(SdpUnknownField)
+			if unknown.IsValid() && (!unknown.CanSet() || unknown.Type() != sdpBytesType) {
+				unknown = reflect.Value{}
+			}
+			if unknown.IsValid() {
+				unknown.SetBytes(nil)
+			}
+			seen := make([]bool, stVal.NumField())
 			for {
+				start := sdp.index
 				err := sdp.unpack(stVal, false)
 				if err == errStructEnd {
 					break
@@ -677,6 +701,22 @@ func (sdp *Sdp) unpack(x reflect.Value, first bool) error {
 				if err != nil {
 					return err
 				}
+				fieldSdp := Sdp{sdp.buf[start:], 0}
+				tag, _, _ := fieldSdp.unpackHeader()
+				if i := sdpFieldIndex(stType, tag); i >= 0 {
+					seen[i] = true
+				} else if unknown.IsValid() {
+					unknown.SetBytes(append(unknown.Bytes(), sdp.buf[start:sdp.index]...))
+				}
+			}
+			for i := 0; i < stVal.NumField(); i++ {
+				def, ok := stType.Field(i).Tag.Lookup("default")
+				if !ok || seen[i] {
+					continue
+				}
+				if err := setDefaultVal(stVal.Field(i), def); err != nil {
+					return err
+				}
 			}
 		}
 	case SdpPackDataType_StructEnd:
@@ -713,6 +753,122 @@ func Decode(x interface{}, data []byte) error {
 	return sdp.unpack(reflect.ValueOf(x).Elem(), true)
 }
 
+//DecodeStrict is like Decode but rejects data which is not in the canonical form produced by Encode
+func DecodeStrict(x interface{}, data []byte) error {
+	if err := ValidateCanonical(data); err != nil {
+		return err
+	}
+	return Decode(x, data)
+}
+
+//ValidateCanonical checks that varints and tag headers use the shortest form
+//and that map keys are unique and sorted by their encoded form
+func ValidateCanonical(data []byte) error {
+	sdp := Sdp{data, 0}
+	for sdp.index < len(sdp.buf) {
+		if err := sdp.checkCanonicalField(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sdp *Sdp) unpackCanonicalNumber() (uint64, error) {
+	start := sdp.index
+	x, err := sdp.unpackNumber()
+	if err != nil {
+		return 0, err
+	}
+	n := 1
+	for v := x; v >= 1<<7; v >>= 7 {
+		n++
+	}
+	if sdp.index-start != n {
+		return 0, errNotCanonical
+	}
+	return x, nil
+}
+
+func (sdp *Sdp) checkCanonicalField() error {
+	if len(sdp.buf) <= sdp.index {
+		return errNoEnoughData
+	}
+	typ := sdp.buf[sdp.index] >> 4
+	longTag := sdp.buf[sdp.index]&0xf == 0xf
+	sdp.index++
+	if longTag {
+		tag, err := sdp.unpackCanonicalNumber()
+		if err != nil {
+			return err
+		}
+		if tag < 15 {
+			return errNotCanonical
+		}
+	}
+
+	switch typ {
+	case SdpPackDataType_Integer_Positive, SdpPackDataType_Integer_Negative, SdpPackDataType_Float, SdpPackDataType_Double:
+		_, err := sdp.unpackCanonicalNumber()
+		return err
+	case SdpPackDataType_String:
+		ln, err := sdp.unpackCanonicalNumber()
+		if err != nil {
+			return err
+		}
+		_, err = sdp.unpackByte(int(ln))
+		return err
+	case SdpPackDataType_Vector:
+		ln, err := sdp.unpackCanonicalNumber()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < int(ln); i++ {
+			if err := sdp.checkCanonicalField(); err != nil {
+				return err
+			}
+		}
+	case SdpPackDataType_Map:
+		ln, err := sdp.unpackCanonicalNumber()
+		if err != nil {
+			return err
+		}
+		var lastKey []byte
+		for i := 0; i < int(ln); i++ {
+			start := sdp.index
+			if err := sdp.checkCanonicalField(); err != nil {
+				return err
+			}
+			key := sdp.buf[start:sdp.index]
+			if i > 0 && bytes.Compare(lastKey, key) >= 0 {
+				return errNotCanonical
+			}
+			lastKey = key
+			if err := sdp.checkCanonicalField(); err != nil {
+				return err
+			}
+		}
+	case SdpPackDataType_StructBegin:
+		for {
+			if len(sdp.buf) <= sdp.index {
+				return errNoEnoughData
+			}
+			if sdp.buf[sdp.index]>>4 == SdpPackDataType_StructEnd {
+				if sdp.buf[sdp.index] != SdpPackDataType_StructEnd<<4 {
+					return errNotCanonical
+				}
+				sdp.index++
+				break
+			}
+			if err := sdp.checkCanonicalField(); err != nil {
+				return err
+			}
+		}
+	default:
+		return errInvalidType
+	}
+	return nil
+}
+
 func PackSdpProtocol(data []byte) []byte {
 	msglen := len(data) + 4
 	sdpMsg := Sdp{}
@@ -0,0 +1,149 @@
+package stnet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var sdpTypeNames = [...]string{"uint", "int", "float", "double", "string", "vector", "map", "struct", "structend"}
+
+func sdpTypeName(typ uint8) string {
+	if int(typ) < len(sdpTypeNames) {
+		return sdpTypeNames[typ]
+	}
+	return "unknown(" + strconv.Itoa(int(typ)) + ")"
+}
+
+//Dump renders the tag tree of sdp encoded data without knowing its schema
+func Dump(data []byte) string {
+	var b bytes.Buffer
+	sdp := Sdp{data, 0}
+	for sdp.index < len(sdp.buf) {
+		if err := sdp.dumpField(&b, 0); err != nil {
+			fmt.Fprintf(&b, "<error at %d: %s>\n", sdp.index, err.Error())
+			break
+		}
+	}
+	return b.String()
+}
+
+func (sdp *Sdp) dumpField(b *bytes.Buffer, depth int) error {
+	tag, typ, err := sdp.unpackHeader()
+	if err != nil {
+		return err
+	}
+	if typ == SdpPackDataType_StructEnd {
+		return errStructEnd
+	}
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(b, "%s%d:%s", indent, tag, sdpTypeName(typ))
+
+	switch typ {
+	case SdpPackDataType_Integer_Positive:
+		v, err := sdp.unpackNumber()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, " %d\n", v)
+	case SdpPackDataType_Float:
+		v, err := sdp.unpackNumber()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, " %g\n", math.Float32frombits(uint32(v)))
+	case SdpPackDataType_Double:
+		v, err := sdp.unpackNumber()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, " %g\n", math.Float64frombits(v))
+	case SdpPackDataType_Integer_Negative:
+		v, err := sdp.unpackNumber()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, " -%d\n", v)
+	case SdpPackDataType_String:
+		ln, err := sdp.unpackNumber()
+		if err != nil {
+			return err
+		}
+		bt, err := sdp.unpackByte(int(ln))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, " %q\n", bt)
+	case SdpPackDataType_Vector, SdpPackDataType_Map:
+		ln, err := sdp.unpackNumber()
+		if err != nil {
+			return err
+		}
+		n := int(ln)
+		if typ == SdpPackDataType_Map {
+			n *= 2
+		}
+		fmt.Fprintf(b, " len=%d [\n", ln)
+		for i := 0; i < n; i++ {
+			if err := sdp.dumpField(b, depth+1); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(b, "%s]\n", indent)
+	case SdpPackDataType_StructBegin:
+		b.WriteString(" {\n")
+		for {
+			err := sdp.dumpField(b, depth+1)
+			if err == errStructEnd {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(b, "%s}\n", indent)
+	default:
+		return errInvalidType
+	}
+	return nil
+}
+
+func newPrototype(prototype interface{}) (reflect.Value, error) {
+	if prototype == nil {
+		return reflect.Value{}, errInvalidType
+	}
+	t := reflect.TypeOf(prototype)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return reflect.New(t), nil
+}
+
+//ToJSON decodes sdp data into a new value of prototype's type and marshals it to json,
+//so field names are used instead of tags
+func ToJSON(data []byte, prototype interface{}) ([]byte, error) {
+	v, err := newPrototype(prototype)
+	if err != nil {
+		return nil, err
+	}
+	if err := Decode(v.Interface(), data); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v.Interface())
+}
+
+//FromJSON unmarshals json into a new value of prototype's type and encodes it by sdp
+func FromJSON(js []byte, prototype interface{}) ([]byte, error) {
+	v, err := newPrototype(prototype)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(js, v.Interface()); err != nil {
+		return nil, err
+	}
+	return Encode(v.Elem().Interface()), nil
+}
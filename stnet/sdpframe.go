@@ -0,0 +1,227 @@
+package stnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+//frame header v1: magic(1) version(1) flags(1) reserved(1) length(4, including header and crc trailer)
+//the old header is a bare 4 bytes length, its first byte is never the magic for frames shorter than 2G
+const (
+	SdpFrameMagic   = 0xa5
+	SdpFrameVersion = 1
+	SdpFrameHeadLen = 8
+
+	SdpFrameFlate = 1 << 0 //payload is compressed by compress/flate
+	SdpFrameZlib  = 1 << 1 //payload is compressed by compress/zlib
+	SdpFrameCRC32 = 1 << 2 //crc32(IEEE) of the payload follows it
+	SdpFrameHello = 1 << 6 //negotiation frame sent on connect
+	SdpFrameAck   = 1 << 7 //reply of the negotiation frame
+
+	sdpFrameCompress = SdpFrameFlate | SdpFrameZlib
+
+	//decompressed payload larger than this is rejected
+	MaxSdpFrameSize = 64 << 20
+)
+
+var (
+	ErrFrameInvalid = errors.New("invalid sdp frame")
+	ErrFrameCRC     = errors.New("sdp frame crc32 mismatch")
+	ErrFrameTooBig  = errors.New("sdp frame too big")
+)
+
+//FrameOption is what one side wants (client) or supports (server),
+//after negotiation it is what both sides use for sending
+type FrameOption struct {
+	Flags     uint8 //SdpFrameFlate, SdpFrameZlib, SdpFrameCRC32
+	Threshold int   //payloads shorter than this are not compressed
+}
+
+var DefaultFrameOption = FrameOption{SdpFrameFlate | SdpFrameZlib | SdpFrameCRC32, 1024}
+
+type frameHello struct {
+	Version   uint32 `tag:"0"`
+	Flags     uint32 `tag:"1"`
+	Threshold uint32 `tag:"2"`
+}
+
+func compressPayload(data []byte, flag uint8) ([]byte, error) {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	if flag == SdpFrameFlate {
+		w, err = flate.NewWriter(&b, flate.DefaultCompression)
+	} else {
+		w, err = zlib.NewWriterLevel(&b, zlib.DefaultCompression)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decompressPayload(data []byte, flags uint8) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	if flags&SdpFrameFlate != 0 {
+		r = flate.NewReader(bytes.NewReader(data))
+	} else {
+		r, err = zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(r, MaxSdpFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MaxSdpFrameSize {
+		return nil, ErrFrameTooBig
+	}
+	return out, nil
+}
+
+//PackSdpFrame packs data with the v1 header, the payload is compressed if flags allow it
+//and it is not shorter than threshold
+func PackSdpFrame(data []byte, flags uint8, threshold int) []byte {
+	flags &^= SdpFrameHello | SdpFrameAck
+	sendFlags := flags &^ sdpFrameCompress
+	payload := data
+	if flags&sdpFrameCompress != 0 && len(data) >= threshold {
+		flag := uint8(SdpFrameFlate)
+		if flags&SdpFrameFlate == 0 {
+			flag = SdpFrameZlib
+		}
+		if c, err := compressPayload(data, flag); err == nil && len(c) < len(data) {
+			payload = c
+			sendFlags |= flag
+		}
+	}
+	return packSdpFrame(payload, sendFlags)
+}
+
+func packSdpFrame(payload []byte, flags uint8) []byte {
+	msglen := SdpFrameHeadLen + len(payload)
+	if flags&SdpFrameCRC32 != 0 {
+		msglen += 4
+	}
+	sdpMsg := Sdp{make([]byte, 0, msglen), 0}
+	sdpMsg.packByte(SdpFrameMagic)
+	sdpMsg.packByte(SdpFrameVersion)
+	sdpMsg.packByte(flags)
+	sdpMsg.packByte(0)
+	sdpMsg.packByte(byte(msglen >> 24))
+	sdpMsg.packByte(byte(msglen >> 16))
+	sdpMsg.packByte(byte(msglen >> 8))
+	sdpMsg.packByte(byte(msglen))
+	sdpMsg.packData(payload)
+	if flags&SdpFrameCRC32 != 0 {
+		crc := crc32.ChecksumIEEE(payload)
+		sdpMsg.packByte(byte(crc >> 24))
+		sdpMsg.packByte(byte(crc >> 16))
+		sdpMsg.packByte(byte(crc >> 8))
+		sdpMsg.packByte(byte(crc))
+	}
+	return sdpMsg.buf
+}
+
+//UnpackSdpFrame reads one frame of the old or the v1 format,
+//lenParsed is 0 if data does not hold a whole frame yet
+func UnpackSdpFrame(data []byte) (lenParsed int, flags uint8, payload []byte, err error) {
+	if len(data) < 4 {
+		return 0, 0, nil, nil
+	}
+	if data[0] != SdpFrameMagic {
+		msgLen := int(SdpLen(data))
+		if msgLen < 4 {
+			return len(data), 0, nil, ErrFrameInvalid
+		}
+		if len(data) < msgLen {
+			return 0, 0, nil, nil
+		}
+		return msgLen, 0, data[4:msgLen], nil
+	}
+
+	if len(data) < SdpFrameHeadLen {
+		return 0, 0, nil, nil
+	}
+	flags = data[2]
+	msgLen := int(SdpLen(data[4:]))
+	minLen := SdpFrameHeadLen
+	if flags&SdpFrameCRC32 != 0 {
+		minLen += 4
+	}
+	if data[1] != SdpFrameVersion || msgLen < minLen {
+		return len(data), 0, nil, ErrFrameInvalid
+	}
+	if len(data) < msgLen {
+		return 0, 0, nil, nil
+	}
+	payload = data[SdpFrameHeadLen:msgLen]
+	if flags&SdpFrameCRC32 != 0 {
+		crc := SdpLen(payload[len(payload)-4:])
+		payload = payload[:len(payload)-4]
+		if crc32.ChecksumIEEE(payload) != crc {
+			return msgLen, flags, nil, ErrFrameCRC
+		}
+	}
+	if flags&sdpFrameCompress != 0 {
+		payload, err = decompressPayload(payload, flags)
+		if err != nil {
+			return msgLen, flags, nil, err
+		}
+	}
+	return msgLen, flags, payload, nil
+}
+
+//unpackSessionFrame reads one frame received by sess, negotiation frames are handled here
+//and return nil payload; local is the option supported by a service, or nil for a connect
+func unpackSessionFrame(sess *Session, data []byte, local *FrameOption) (lenParsed int, payload []byte, err error) {
+	var flags uint8
+	lenParsed, flags, payload, err = UnpackSdpFrame(data)
+	if err != nil || lenParsed == 0 || flags&(SdpFrameHello|SdpFrameAck) == 0 {
+		return
+	}
+
+	hello := frameHello{}
+	if e := Decode(&hello, payload); e != nil {
+		return lenParsed, nil, e
+	}
+	if flags&SdpFrameAck != 0 {
+		sess.setFrameOption(&FrameOption{uint8(hello.Flags), int(hello.Threshold)})
+	} else if local != nil {
+		common := uint8(hello.Flags) & local.Flags
+		sess.setFrameOption(&FrameOption{common, local.Threshold})
+		ack := frameHello{SdpFrameVersion, uint32(common), hello.Threshold}
+		err = sess.Send(packSdpFrame(Encode(ack), SdpFrameHello|SdpFrameAck))
+	}
+	return lenParsed, nil, err
+}
+
+//sendFrameHello starts the negotiation, frames are sent in the old format until the peer replies,
+//so it should only be used when the peer understands the v1 header
+func sendFrameHello(sess *Session, want FrameOption) error {
+	hello := frameHello{SdpFrameVersion, uint32(want.Flags), uint32(want.Threshold)}
+	return sess.Send(packSdpFrame(Encode(hello), SdpFrameHello))
+}
+
+//SendSdp sends sdp encoded data with the frame option negotiated on sess
+func SendSdp(sess *Session, data []byte) error {
+	opt := sess.FrameOption()
+	if opt == nil {
+		return sess.Send(PackSdpProtocol(data))
+	}
+	return sess.Send(PackSdpFrame(data, opt.Flags, opt.Threshold))
+}
@@ -44,7 +44,14 @@ func (svr *Server) AddService(name, address string, imp ServiceImp, threadId int
 }
 
 func (svr *Server) AddRpcService(name, address string, rpcFuncStruct interface{}, threadId int) (*Service, error) {
-	rpcImp := &RPCServerImp{rpcFuncStruct}
+	return svr.AddRpcServiceWithOption(name, address, rpcFuncStruct, threadId, RpcServerOption{})
+}
+
+func (svr *Server) AddRpcServiceWithOption(name, address string, rpcFuncStruct interface{}, threadId int, opt RpcServerOption) (*Service, error) {
+	rpcImp, e := newRPCServerImp(name, rpcFuncStruct, opt)
+	if e != nil {
+		return nil, e
+	}
 	s, e := newService(name, address, rpcImp)
 	if e != nil {
 		return nil, e
@@ -72,7 +79,7 @@ func (svr *Server) AddConnectNoStart(name, address string, reconnectmsec int, im
 }
 
 func (svr *Server) AddRpcClient(name, servicename, address string, threadId int) (*RPC, error) {
-	r, e := newRPC(name, servicename, address)
+	r, e := newRPC(name, servicename, address, nil)
 	if e != nil {
 		return nil, e
 	}
@@ -81,11 +88,31 @@ func (svr *Server) AddRpcClient(name, servicename, address string, threadId int)
 	return r, e
 }
 
+//AddRpcClientWithResolver connects to the addresses of servicename found by r
+func (svr *Server) AddRpcClientWithResolver(name, servicename string, r Resolver, threadId int) (*RPC, error) {
+	rpc, e := newRPC(name, servicename, "", r)
+	if e != nil {
+		return nil, e
+	}
+	svr.connects[threadId] = append(svr.connects[threadId], rpc.Connect)
+	svr.AddNullService(name, rpc.rpcimp, threadId)
+	return rpc, e
+}
+
+func (svr *Server) AddConnectWithResolver(name, servicename string, r Resolver, reconnectmsec int, imp ConnectImp, threadId int) (*Connect, error) {
+	c, e := newResolverConnect(name, servicename, r, reconnectmsec, imp)
+	if e != nil {
+		return nil, e
+	}
+	svr.connects[threadId] = append(svr.connects[threadId], c)
+	return c, e
+}
+
 func (svr *Server) Start() error {
 	for _, v := range svr.services {
 		for _, s := range v {
 			if !s.imp.Init() {
-				return fmt.Errorf(s.Name + " init failed!")
+				return fmt.Errorf("%s init failed!", s.Name)
 			}
 			s.imp.RegisterSMessage(s)
 		}
@@ -94,7 +121,7 @@ func (svr *Server) Start() error {
 	for _, v := range svr.nullservices {
 		for _, s := range v {
 			if !s.imp.Init() {
-				return fmt.Errorf(s.Name + " init failed!")
+				return fmt.Errorf("%s init failed!", s.Name)
 			}
 		}
 	}
@@ -110,9 +137,9 @@ func (svr *Server) Start() error {
 		keyUsed[k] = 1
 		ct, _ := svr.connects[k]
 		ns, _ := svr.nullservices[k]
+		svr.wg.Add(1)
 		go func(ss []*Service, sn []*NullService, cc []*Connect) {
-			svr.wg.Add(1)
-			for svr.isclose == 0 {
+			for atomic.LoadUint32(&svr.isclose) == 0 {
 				for _, s := range ss {
 					s.loop()
 					s.imp.Loop()
@@ -133,9 +160,9 @@ func (svr *Server) Start() error {
 		if _, ok := keyUsed[k]; ok {
 			continue
 		}
+		svr.wg.Add(1)
 		go func(ns []*NullService) {
-			svr.wg.Add(1)
-			for svr.isclose == 0 {
+			for atomic.LoadUint32(&svr.isclose) == 0 {
 				for _, s := range ns {
 					s.imp.Loop()
 				}
@@ -149,9 +176,9 @@ func (svr *Server) Start() error {
 		if _, ok := keyUsed[k]; ok {
 			continue
 		}
+		svr.wg.Add(1)
 		go func(cc []*Connect) {
-			svr.wg.Add(1)
-			for svr.isclose == 0 {
+			for atomic.LoadUint32(&svr.isclose) == 0 {
 				for _, c := range cc {
 					c.loop()
 				}
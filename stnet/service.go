@@ -2,13 +2,14 @@ package stnet
 
 import (
 	"fmt"
+	"time"
 )
 
 func newService(name, address string, imp ServiceImp) (*Service, error) {
 	if imp == nil {
 		return nil, fmt.Errorf("ServiceImp should not be nil")
 	}
-	svr := &Service{name, nil, imp, make(chan sessionMessage, 1024), make(map[uint32]FuncHandleMessage)}
+	svr := &Service{name, nil, imp, make(chan sessionMessage, 1024), make(map[uint32]FuncHandleMessage), nil, newHandlerMetrics(name)}
 	lis, err := NewListener(address, svr)
 	if err != nil {
 		return nil, err
@@ -24,6 +25,27 @@ func (service *Service) RegisterMessage(msgID uint32, handler FuncHandleMessage)
 	service.messageHandlers[msgID] = handler
 }
 
+//RegisterRpcService adds another named service to the listener of a rpc service,
+//requests are routed by RequestPacket.ServiceName; it should be called before the server starts
+func (service *Service) RegisterRpcService(name string, rpcFuncStruct interface{}) error {
+	rpc, ok := service.imp.(*RPCServerImp)
+	if !ok {
+		return fmt.Errorf("%s is not a rpc service", service.Name)
+	}
+	return rpc.addService(name, rpcFuncStruct)
+}
+
+//Register registers the service by its name with address, the address clients should dial,
+//the registration is renewed until the service is destroyed
+func (service *Service) Register(reg Registrar, address string, ttl time.Duration) error {
+	stop, err := KeepRegistered(reg, service.Name, address, ttl)
+	if err != nil {
+		return err
+	}
+	service.unregister = stop
+	return nil
+}
+
 type NullService struct {
 	Name string
 	imp  NullServiceImp
@@ -35,6 +57,8 @@ type Service struct {
 	imp             ServiceImp
 	messageQ        chan sessionMessage
 	messageHandlers map[uint32]FuncHandleMessage
+	unregister      func()
+	stats           *handlerMetrics
 }
 
 type sessionMessage struct {
@@ -46,6 +70,7 @@ type sessionMessage struct {
 }
 
 func (service *Service) loop() {
+	service.stats.queueDepth.Add(float64(len(service.messageQ)))
 	for i := 0; i < 100; i++ {
 		select {
 		case msg := <-service.messageQ:
@@ -57,7 +82,9 @@ func (service *Service) loop() {
 				service.imp.SessionClose(msg.Sess)
 			} else if msg.DtType == Data {
 				if handler, ok := service.messageHandlers[msg.MsgID]; ok {
+					start := time.Now()
 					handler(msg.Sess, msg.Msg)
+					service.stats.observeHandler(msg.MsgID, start)
 				} else {
 					service.imp.HandleError(msg.Sess, fmt.Errorf("message handler not find"))
 				}
@@ -68,11 +95,19 @@ func (service *Service) loop() {
 	}
 }
 func (service *Service) destroy() {
+	if service.unregister != nil {
+		service.unregister()
+	}
 	service.listen.Close()
 }
 func (service *Service) ParseMsg(sess *Session, data []byte) int {
 	lenParsed, msgid, msg, e := service.imp.Unmarshal(sess, data)
-	service.messageQ <- sessionMessage{sess, Data, msgid, msg, e}
+	if msg != nil {
+		service.stats.messagesIn.Add(1)
+	}
+	if msg != nil || e != nil {
+		service.messageQ <- sessionMessage{sess, Data, msgid, msg, e}
+	}
 	return lenParsed
 }
 func (service *Service) SessionEvent(sess *Session, cmd CMDType) {
@@ -83,7 +118,7 @@ func newConnect(name, address string, reconnectmsec int, imp ConnectImp) (*Conne
 	if imp == nil {
 		return nil, fmt.Errorf("ServiceImp should not be nil")
 	}
-	conn := &Connect{nil, name, imp, make(chan sessionMessage, 1024), make(map[uint32]FuncHandleMessage)}
+	conn := &Connect{nil, name, imp, make(chan sessionMessage, 1024), make(map[uint32]FuncHandleMessage), newHandlerMetrics(name)}
 	ct, err := NewConnector(address, reconnectmsec, conn, nil)
 	if err != nil {
 		return nil, err
@@ -96,7 +131,7 @@ func newConnectNoStart(name, address string, reconnectmsec int, imp ConnectImp)
 	if imp == nil {
 		return nil, fmt.Errorf("ServiceImp should not be nil")
 	}
-	conn := &Connect{nil, name, imp, make(chan sessionMessage, 1024), make(map[uint32]FuncHandleMessage)}
+	conn := &Connect{nil, name, imp, make(chan sessionMessage, 1024), make(map[uint32]FuncHandleMessage), newHandlerMetrics(name)}
 	ct, err := NewConnectorNoStart(address, reconnectmsec, conn, nil)
 	if err != nil {
 		return nil, err
@@ -105,6 +140,19 @@ func newConnectNoStart(name, address string, reconnectmsec int, imp ConnectImp)
 	return conn, nil
 }
 
+func newResolverConnect(name, servicename string, r Resolver, reconnectmsec int, imp ConnectImp) (*Connect, error) {
+	if imp == nil {
+		return nil, fmt.Errorf("ServiceImp should not be nil")
+	}
+	conn := &Connect{nil, name, imp, make(chan sessionMessage, 1024), make(map[uint32]FuncHandleMessage), newHandlerMetrics(name)}
+	ct, err := NewResolverConnector(r, servicename, reconnectmsec, conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.Connector = ct
+	return conn, nil
+}
+
 func (ct *Connect) RegisterMessage(msgID uint32, handler FuncHandleMessage) {
 	if handler == nil {
 		return
@@ -118,9 +166,11 @@ type Connect struct {
 	imp             ConnectImp
 	messageQ        chan sessionMessage
 	messageHandlers map[uint32]FuncHandleMessage
+	stats           *handlerMetrics
 }
 
 func (ct *Connect) loop() {
+	ct.stats.queueDepth.Add(float64(len(ct.messageQ)))
 	for i := 0; i < 100; i++ {
 		select {
 		case msg := <-ct.messageQ:
@@ -131,7 +181,9 @@ func (ct *Connect) loop() {
 			} else if msg.DtType == Close {
 				ct.imp.DisConnected(msg.Sess)
 			} else if handler, ok := ct.messageHandlers[msg.MsgID]; ok {
+				start := time.Now()
 				handler(msg.Sess, msg.Msg)
+				ct.stats.observeHandler(msg.MsgID, start)
 			} else {
 				ct.imp.HandleError(msg.Sess, fmt.Errorf("message handler not find"))
 			}
@@ -145,7 +197,12 @@ func (ct *Connect) destroy() {
 }
 func (ct *Connect) ParseMsg(sess *Session, data []byte) int {
 	lenParsed, msgid, msg, e := ct.imp.Unmarshal(sess, data)
-	ct.messageQ <- sessionMessage{sess, Data, msgid, msg, e}
+	if msg != nil {
+		ct.stats.messagesIn.Add(1)
+	}
+	if msg != nil || e != nil {
+		ct.messageQ <- sessionMessage{sess, Data, msgid, msg, e}
+	}
 	return lenParsed
 }
 func (ct *Connect) SessionEvent(sess *Session, cmd CMDType) {
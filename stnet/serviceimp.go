@@ -3,8 +3,9 @@ package stnet
 import (
 	"bufio"
 	"bytes"
-	"fmt"
 	"net/http"
+
+	"github.com/sstask/golib/stlog"
 )
 
 type FuncHandleMessage func(*Session, interface{})
@@ -42,6 +43,7 @@ type ConnectImp interface {
 
 //ServiceImpEcho
 type ServiceEcho struct {
+	Log *stlog.Logger //errors of the sessions, stnet.Logger() if nil
 }
 
 func (service *ServiceEcho) Init() bool {
@@ -67,11 +69,12 @@ func (service *ServiceEcho) SessionClose(sess *Session) {
 
 }
 func (service *ServiceEcho) HandleError(sess *Session, err error) {
-	fmt.Println(err.Error())
+	logError(service.Log, sess, err)
 }
 
 //ServiceHttp
 type ServiceHttp struct {
+	Log *stlog.Logger //errors of the sessions, stnet.Logger() if nil
 }
 
 func (service *ServiceHttp) Init() bool {
@@ -103,7 +106,7 @@ func (service *ServiceHttp) SessionClose(sess *Session) {
 
 }
 func (service *ServiceHttp) HandleError(sess *Session, err error) {
-	fmt.Println(err.Error())
+	logError(service.Log, sess, err)
 }
 
 //ServiceImpSdp
@@ -120,6 +123,15 @@ type RspProto struct {
 	RspData   string `tag:"6"`
 }
 type ServiceSdp struct {
+	Frame *FrameOption  //options supported in negotiation, DefaultFrameOption if nil
+	Log   *stlog.Logger //errors of the sessions, stnet.Logger() if nil
+}
+
+func (service *ServiceSdp) frameOption() *FrameOption {
+	if service.Frame == nil {
+		return &DefaultFrameOption
+	}
+	return service.Frame
 }
 
 func (service *ServiceSdp) Init() bool {
@@ -138,19 +150,16 @@ func (service *ServiceSdp) RegisterSMessage(s *Service) {
 	s.RegisterMessage(0, service.HandleReqProto)
 }
 func (service *ServiceSdp) Unmarshal(sess *Session, data []byte) (lenParsed int, msgID uint32, msg interface{}, err error) {
-	if len(data) < 4 {
-		return 0, 0, nil, nil
-	}
-	msgLen := SdpLen(data)
-	if len(data) < int(msgLen) {
-		return 0, 0, nil, nil
+	msgLen, payload, e := unpackSessionFrame(sess, data, service.frameOption())
+	if e != nil || payload == nil {
+		return msgLen, 0, nil, e
 	}
 	req := &ReqProto{}
-	e := Decode(req, data[4:msgLen])
+	e = Decode(req, payload)
 	if e != nil {
-		return int(msgLen), 0, nil, e
+		return msgLen, 0, nil, e
 	}
-	return int(msgLen), 0, req, nil
+	return msgLen, 0, req, nil
 }
 func (service *ServiceSdp) SessionOpen(sess *Session) {
 
@@ -159,10 +168,12 @@ func (service *ServiceSdp) SessionClose(sess *Session) {
 
 }
 func (service *ServiceSdp) HandleError(sess *Session, err error) {
-	fmt.Println(err.Error())
+	logError(service.Log, sess, err)
 }
 
 type ConnectSdp struct {
+	Frame *FrameOption  //options wanted in negotiation, frames are not negotiated if nil
+	Log   *stlog.Logger //errors of the session, stnet.Logger() if nil
 }
 
 func (cs *ConnectSdp) HandleRspProto(s *Session, msg interface{}) {
@@ -172,27 +183,29 @@ func (cs *ConnectSdp) RegisterCMessage(c *Connect) {
 	c.RegisterMessage(0, cs.HandleRspProto)
 }
 func (cs *ConnectSdp) Unmarshal(sess *Session, data []byte) (lenParsed int, msgID uint32, msg interface{}, err error) {
-	if len(data) < 4 {
-		return 0, 0, nil, nil
-	}
-	msgLen := SdpLen(data)
-	if len(data) < int(msgLen) {
-		return 0, 0, nil, nil
+	msgLen, payload, e := unpackSessionFrame(sess, data, nil)
+	if e != nil || payload == nil {
+		return msgLen, 0, nil, e
 	}
 	rsp := &RspProto{}
-	e := Decode(rsp, data[4:msgLen])
+	e = Decode(rsp, payload)
 	if e != nil {
-		return int(msgLen), 0, nil, e
+		return msgLen, 0, nil, e
 	}
-	return int(msgLen), 0, rsp, nil
+	return msgLen, 0, rsp, nil
 }
 
 func (cs *ConnectSdp) Connected(sess *Session) {
+	if cs.Frame != nil {
+		if err := sendFrameHello(sess, *cs.Frame); err != nil {
+			cs.HandleError(sess, err)
+		}
+	}
 
 }
 func (cs *ConnectSdp) DisConnected(sess *Session) {
 
 }
 func (cs *ConnectSdp) HandleError(s *Session, err error) {
-	fmt.Println(err.Error())
+	logError(cs.Log, s, err)
 }
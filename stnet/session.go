@@ -2,7 +2,7 @@ package stnet
 
 import (
 	"errors"
-
+	"io"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -62,6 +62,8 @@ type Session struct {
 	wg      *sync.WaitGroup
 	onclose FuncOnClose
 	isclose uint32
+	frame   atomic.Value //*FrameOption negotiated with the peer
+	reason  uint32       //why it closed, closeByPeer...
 
 	UserData interface{}
 }
@@ -112,6 +114,8 @@ func (s *Session) restart(con net.Conn) error {
 	}
 	s.closer = make(chan int)
 	s.socket = con
+	s.setFrameOption(nil)
+	atomic.StoreUint32(&s.reason, closeByPeer)
 	asyncDo(s.dosend, s.wg)
 	asyncDo(s.dohand, s.wg)
 	go s.dorecv()
@@ -122,7 +126,20 @@ func (s *Session) GetID() uint64 {
 	return s.id
 }
 
+//FrameOption returns the sdp frame option negotiated with the peer, nil if not negotiated
+func (s *Session) FrameOption() *FrameOption {
+	opt, _ := s.frame.Load().(*FrameOption)
+	return opt
+}
+
+func (s *Session) setFrameOption(opt *FrameOption) {
+	s.frame.Store(opt)
+}
+
 func (s *Session) Send(data []byte) error {
+	if s.IsClose() { //select may pick the writer even if closer is closed
+		return ErrSocketClosed
+	}
 	msg := bp.Alloc(len(data))
 	copy(msg, data)
 	for {
@@ -132,12 +149,16 @@ func (s *Session) Send(data []byte) error {
 		case s.writer <- msg:
 			return nil
 		case <-time.After(100 * time.Millisecond):
+			sendTimeoutMetric.Add(1)
 			return ErrSendOverTime
 		}
 	}
 }
 
 func (s *Session) AsyncSend(data []byte) error {
+	if s.IsClose() {
+		return ErrSocketClosed
+	}
 	msg := bp.Alloc(len(data))
 	copy(msg, data)
 	for {
@@ -147,12 +168,14 @@ func (s *Session) AsyncSend(data []byte) error {
 		case s.writer <- msg:
 			return nil
 		default:
+			queueFullMetric.Add(1)
 			return ErrSendBuffIsFull
 		}
 	}
 }
 
 func (s *Session) Close() {
+	atomic.CompareAndSwapUint32(&s.reason, closeByPeer, closeByLocal)
 	s.socket.Close()
 }
 
@@ -167,9 +190,12 @@ func (s *Session) dosend() {
 			return
 		case buf := <-s.writer:
 			if _, err := s.socket.Write(buf); err != nil {
+				atomic.CompareAndSwapUint32(&s.reason, closeByPeer, closeByWriteError)
 				s.socket.Close()
 				return
 			}
+			bytesOutMetric.Add(float64(len(buf)))
+			messagesOutMetric.Add(1)
 			bp.Free(buf)
 		}
 	}
@@ -177,11 +203,16 @@ func (s *Session) dosend() {
 
 func (s *Session) dorecv() {
 	s.SessionEvent(s, Open)
+	sessionOpened()
 
 	msgbuf := bp.Alloc(MsgBuffSize)
 	for {
 		n, err := s.socket.Read(msgbuf)
 		if err != nil {
+			if err != io.EOF {
+				atomic.CompareAndSwapUint32(&s.reason, closeByPeer, closeByReadError)
+			}
+			sessionClosed(atomic.LoadUint32(&s.reason))
 			s.SessionEvent(s, Close)
 			s.socket.Close()
 			close(s.closer)
@@ -190,13 +221,18 @@ func (s *Session) dorecv() {
 			s.onclose(s)
 			return
 		}
+		bytesInMetric.Add(float64(n))
 		s.hander <- msgbuf[0:n]
 
+		//dohand frees the buffer it got and may keep a partial message in it,
+		//so the next read goes into a new one
 		bufLen := len(msgbuf)
 		if MinMsgSize < bufLen && n*2 < bufLen {
 			msgbuf = bp.Alloc(bufLen / 2)
 		} else if n == bufLen {
 			msgbuf = bp.Alloc(bufLen * 2)
+		} else {
+			msgbuf = bp.Alloc(bufLen)
 		}
 	}
 }
@@ -0,0 +1,215 @@
+package stnet
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sstask/golib/stlog"
+)
+
+//the W3C trace context of a call travels in RequestPacket.Context with this key
+const RpcContextTraceParent = "traceparent"
+
+//Span is a timed operation of a trace, rpc calls are recorded as "client" spans
+//and the handling of them as "server" spans
+type Span struct {
+	TraceID  string            `json:"trace_id"`
+	SpanID   string            `json:"span_id"`
+	ParentID string            `json:"parent_id,omitempty"`
+	Name     string            `json:"name"`
+	Kind     string            `json:"kind"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Code     int32             `json:"code"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+}
+
+//SpanExporter receives finished spans, it may be called from many goroutines
+type SpanExporter interface {
+	Export(span *Span)
+}
+
+type exporterHolder struct {
+	SpanExporter
+}
+
+var spanExporterVal atomic.Value
+
+func init() {
+	spanExporterVal.Store(exporterHolder{})
+	stlog.SetTraceIDExtractor(TraceIDFromContext)
+}
+
+//SetSpanExporter starts tracing rpc calls, nil stops it
+func SetSpanExporter(e SpanExporter) {
+	spanExporterVal.Store(exporterHolder{e})
+}
+
+func spanExporter() SpanExporter {
+	return spanExporterVal.Load().(exporterHolder).SpanExporter
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHexID(s string, n int) bool {
+	if len(s) != n || s == strings.Repeat("0", n) {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+//parseTraceParent returns the trace id and the parent span id of "00-traceid-spanid-flags"
+func parseTraceParent(tp string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	if !isHexID(parts[1], 32) || !isHexID(parts[2], 16) {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func (s *Span) TraceParent() string {
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
+
+type spanContextKey struct{}
+
+//SpanFromContext returns the span started by StartSpan or the server span of a call handled with ctx
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanContextKey{}).(*Span)
+	return s
+}
+
+func contextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, s)
+}
+
+//TraceIDFromContext returns the trace id of the span of ctx, or of the call handled with ctx
+func TraceIDFromContext(ctx context.Context) string {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.TraceID
+	}
+	if traceID, _, ok := parseTraceParent(RpcContextMap(ctx)[RpcContextTraceParent]); ok {
+		return traceID
+	}
+	return ""
+}
+
+func newSpan(traceID, parentID, name, kind string) *Span {
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+	return &Span{TraceID: traceID, SpanID: randomHex(8), ParentID: parentID, Name: name, Kind: kind, Start: time.Now()}
+}
+
+func startChildSpan(ctx context.Context, name, kind string) *Span {
+	if parent := SpanFromContext(ctx); parent != nil {
+		return newSpan(parent.TraceID, parent.SpanID, name, kind)
+	}
+	traceID, parentID, _ := parseTraceParent(RpcContextMap(ctx)[RpcContextTraceParent])
+	return newSpan(traceID, parentID, name, kind)
+}
+
+//StartSpan starts a span under the span of ctx, it is exported by Finish; it returns nil while tracing is off
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if spanExporter() == nil {
+		return ctx, nil
+	}
+	s := startChildSpan(ctx, name, "internal")
+	return contextWithSpan(ctx, s), s
+}
+
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.Attrs == nil {
+		s.Attrs = make(map[string]string)
+	}
+	s.Attrs[key] = value
+}
+
+//Finish ends the span and exports it, it does nothing on nil
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if e := spanExporter(); e != nil {
+		e.Export(s)
+	}
+}
+
+func (s *Span) finishRpc(rsp *ResponsePacket) {
+	if s != nil && rsp != nil {
+		s.Code = rsp.MfwRet
+	}
+	s.Finish()
+}
+
+/****** exporters ******/
+
+//MemoryExporter keeps spans in memory for tests
+type MemoryExporter struct {
+	sync.Mutex
+	spans []*Span
+}
+
+func (e *MemoryExporter) Export(span *Span) {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func (e *MemoryExporter) Spans() []*Span {
+	e.Lock()
+	defer e.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+func (e *MemoryExporter) Reset() {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = nil
+}
+
+//JSONFileExporter appends each span to a file as a line of json
+type JSONFileExporter struct {
+	sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewJSONFileExporter(path string) (*JSONFileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileExporter{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (e *JSONFileExporter) Export(span *Span) {
+	e.Lock()
+	defer e.Unlock()
+	e.enc.Encode(span)
+}
+
+func (e *JSONFileExporter) Close() error {
+	e.Lock()
+	defer e.Unlock()
+	return e.file.Close()
+}